@@ -0,0 +1,639 @@
+// Package adminapi 基于 PermissionService 提供角色管理的 JSON HTTP 接口
+//
+// 路由列表，挂载前缀由调用方通过 http.StripPrefix 等方式自行处理:
+//
+//	GET    /roleables/{roleable_type}/{roleable_id}/roles                    分页获取角色列表，查询参数见 QueryRolesParam
+//	POST   /roleables/{roleable_type}/{roleable_id}/roles                    创建角色
+//	GET    /roleables/{roleable_type}/{roleable_id}/roles/{role_id}          获取角色及其权限组
+//	PUT    /roleables/{roleable_type}/{roleable_id}/roles/{role_id}          更新角色
+//	DELETE /roleables/{roleable_type}/{roleable_id}/roles/{role_id}          删除角色
+//	POST   /roleables/{roleable_type}/{roleable_id}/roles/{role_id}/clone    在同一对象下复制角色
+//	GET    /roleables/{roleable_type}/{roleable_id}/deleted-roles            获取已删除的角色列表
+//	POST   /roleables/{roleable_type}/{roleable_id}/deleted-roles/{role_id}/restore  恢复已删除的角色
+//	GET    /roleables/{roleable_type}/{roleable_id}/users/{user_id}/roles    获取用户角色列表
+//	PUT    /roleables/{roleable_type}/{roleable_id}/users/{user_id}/roles    为用户分配角色
+//	PUT    /roleables/{roleable_type}/{roleable_id}/users/{user_id}/roles/{role_id}  为用户添加单个角色
+//	DELETE /roleables/{roleable_type}/{roleable_id}/users/{user_id}/roles/{role_id}  移除用户的单个角色
+//	GET    /roleables/{roleable_type}/{roleable_id}/permission-group-tree    获取完整权限组树，可通过 ?domain= 指定权限组 domain，?with_permissions=true 时包含权限
+//
+// 角色列表、用户角色和权限组树通过 ?locale= 或 Accept-Language 指定标题和描述的语言
+//
+// 成功时返回 JSON 对象，失败时返回 {"code": "...", "message": "..."}，code 取值见 Code* 常量
+//
+// 所有接口都会先通过 UserIDFunc 识别当前用户，再通过授权函数校验当前用户能否管理该对象下的角色，
+// 未配置授权函数时拒绝所有请求。创建、更新、复制、删除、恢复角色和分配、移除用户角色时，当前用户只能授予或移除自己在该对象下拥有的权限组，
+// 创建已存在的角色名返回 conflict
+package adminapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"git.sofunny.io/data-analysis/gotools/permission"
+)
+
+// 错误码
+const (
+	CodeInvalidArgument    = "invalid_argument"
+	CodeUnauthenticated    = "unauthenticated"
+	CodePermissionDenied   = "permission_denied"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeFailedPrecondition = "failed_precondition"
+	CodeInternal           = "internal"
+)
+
+// 接口错误
+type Error struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func invalidArgument(format string, args ...any) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeInvalidArgument, Message: fmt.Sprintf(format, args...)}
+}
+
+// 从请求中识别当前用户
+type UserIDFunc func(r *http.Request) (int64, error)
+
+// 校验用户能否管理某个对象下的角色，返回 false 时响应 permission_denied
+type Authorizer func(r *http.Request, userID int64, roleableType string, roleableID int64) (bool, error)
+
+type Option func(*Handler)
+
+// 拥有该权限组的用户才可以管理角色
+func WithManagePermissionGroup(permissionGroupName string) Option {
+	return func(h *Handler) {
+		h.authorizer = func(r *http.Request, userID int64, roleableType string, roleableID int64) (bool, error) {
+			return h.svc.HasPermissionGroup(r.Context(), permission.HasPermissionGroupParam{
+				UserID:              userID,
+				RoleableType:        roleableType,
+				RoleableID:          roleableID,
+				PermissionGroupName: permissionGroupName,
+			})
+		}
+	}
+}
+
+// 自定义授权函数，会覆盖 WithManagePermissionGroup
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(h *Handler) {
+		h.authorizer = authorizer
+	}
+}
+
+type Handler struct {
+	svc        *permission.PermissionService
+	userIDFunc UserIDFunc
+	authorizer Authorizer
+	mux        *http.ServeMux
+}
+
+func New(svc *permission.PermissionService, userIDFunc UserIDFunc, opts ...Option) *Handler {
+	h := &Handler{
+		svc:        svc,
+		userIDFunc: userIDFunc,
+		mux:        http.NewServeMux(),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.mux.HandleFunc("GET /roleables/{roleable_type}/{roleable_id}/roles", h.handle(h.getRoles))
+	h.mux.HandleFunc("POST /roleables/{roleable_type}/{roleable_id}/roles", h.handle(h.createRole))
+	h.mux.HandleFunc("GET /roleables/{roleable_type}/{roleable_id}/roles/{role_id}", h.handle(h.getRole))
+	h.mux.HandleFunc("PUT /roleables/{roleable_type}/{roleable_id}/roles/{role_id}", h.handle(h.updateRole))
+	h.mux.HandleFunc("DELETE /roleables/{roleable_type}/{roleable_id}/roles/{role_id}", h.handle(h.deleteRole))
+	h.mux.HandleFunc("POST /roleables/{roleable_type}/{roleable_id}/roles/{role_id}/clone", h.handle(h.cloneRole))
+	h.mux.HandleFunc("GET /roleables/{roleable_type}/{roleable_id}/deleted-roles", h.handle(h.getDeletedRoles))
+	h.mux.HandleFunc("POST /roleables/{roleable_type}/{roleable_id}/deleted-roles/{role_id}/restore", h.handle(h.restoreRole))
+	h.mux.HandleFunc("GET /roleables/{roleable_type}/{roleable_id}/users/{user_id}/roles", h.handle(h.getUserRoles))
+	h.mux.HandleFunc("PUT /roleables/{roleable_type}/{roleable_id}/users/{user_id}/roles", h.handle(h.assignRolesToUser))
+	h.mux.HandleFunc("PUT /roleables/{roleable_type}/{roleable_id}/users/{user_id}/roles/{role_id}", h.handle(h.addUserRole))
+	h.mux.HandleFunc("DELETE /roleables/{roleable_type}/{roleable_id}/users/{user_id}/roles/{role_id}", h.handle(h.removeUserRole))
+	h.mux.HandleFunc("GET /roleables/{roleable_type}/{roleable_id}/permission-group-tree", h.handle(h.getPermissionGroupTree))
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// 请求上下文，包含当前用户和路由中的对象
+type request struct {
+	*http.Request
+	userID       int64
+	roleableType string
+	roleableID   int64
+}
+
+type handlerFunc func(r *request) (int, any, error)
+
+// 统一处理身份识别、授权、响应编码和错误码转换
+func (h *Handler) handle(fn handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := h.authorize(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		status, resp, err := fn(req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, status, resp)
+	}
+}
+
+func (h *Handler) authorize(r *http.Request) (*request, error) {
+	userID, err := h.userIDFunc(r)
+	if err != nil || userID == 0 {
+		return nil, &Error{Status: http.StatusUnauthorized, Code: CodeUnauthenticated, Message: "unauthenticated"}
+	}
+
+	roleableType := r.PathValue("roleable_type")
+	if roleableType == "" || len(roleableType) > 128 {
+		return nil, invalidArgument("invalid roleable_type")
+	}
+	roleableID, err := parseID(r, "roleable_id")
+	if err != nil {
+		return nil, err
+	}
+
+	if h.authorizer == nil {
+		return nil, &Error{Status: http.StatusForbidden, Code: CodePermissionDenied, Message: "permission denied"}
+	}
+	ok, err := h.authorizer(r, userID, roleableType, roleableID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &Error{Status: http.StatusForbidden, Code: CodePermissionDenied, Message: "permission denied"}
+	}
+
+	return &request{
+		Request:      r,
+		userID:       userID,
+		roleableType: roleableType,
+		roleableID:   roleableID,
+	}, nil
+}
+
+type roleResponse struct {
+	*permission.Role
+	PermissionGroups []string `json:"permission_groups"`
+}
+
+type rolesResponse struct {
+	Roles []*permission.Role `json:"roles"`
+}
+
+type userRolesResponse struct {
+	Roles   []*permission.Role `json:"roles"`
+	Version string             `json:"version"`
+}
+
+type permissionGroupTreeResponse struct {
+	PermissionGroups []*permission.PermissionGroupItem `json:"permission_groups"`
+}
+
+func (h *Handler) getRoles(r *request) (int, any, error) {
+	query := r.URL.Query()
+	param := permission.QueryRolesParam{
+		RoleableType: r.roleableType,
+		RoleableID:   r.roleableID,
+		Keyword:      query.Get("keyword"),
+		Kind:         query.Get("kind"),
+		OrderBy:      query.Get("order_by"),
+		Desc:         query.Get("desc") == "true",
+		Cursor:       query.Get("cursor"),
+		Locale:       requestLocale(r.Request),
+	}
+	var err error
+	if param.UserID, err = parseQueryInt(query.Get("user_id"), "user_id"); err != nil {
+		return 0, nil, err
+	}
+	if param.CreatorUserID, err = parseQueryInt(query.Get("creator_user_id"), "creator_user_id"); err != nil {
+		return 0, nil, err
+	}
+	limit, err := parseQueryInt(query.Get("limit"), "limit")
+	if err != nil {
+		return 0, nil, err
+	}
+	offset, err := parseQueryInt(query.Get("offset"), "offset")
+	if err != nil {
+		return 0, nil, err
+	}
+	param.Limit, param.Offset = int(limit), int(offset)
+
+	result, err := h.svc.QueryRoles(r.Context(), param)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, result, nil
+}
+
+type createRoleRequest struct {
+	Name             string   `json:"name"`
+	Title            string   `json:"title"`
+	Description      string   `json:"description"`
+	PermissionGroups []string `json:"permission_groups"`
+}
+
+var roleNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,255}$`)
+
+func (h *Handler) createRole(r *request) (int, any, error) {
+	var body createRoleRequest
+	if err := decodeJSON(r.Request, &body); err != nil {
+		return 0, nil, err
+	}
+	if !roleNameRegexp.MatchString(body.Name) {
+		return 0, nil, invalidArgument("name must match %s", roleNameRegexp.String())
+	}
+	if err := validateRoleFields(body.Title, body.PermissionGroups); err != nil {
+		return 0, nil, err
+	}
+
+	role, err := h.svc.CreateRoleAs(r.Context(), r.userID, permission.CreateRoleParam{
+		RoleableType:     r.roleableType,
+		RoleableID:       r.roleableID,
+		Name:             body.Name,
+		Title:            body.Title,
+		Description:      body.Description,
+		PermissionGroups: body.PermissionGroups,
+		CreatorUserID:    r.userID,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, roleResponse{Role: role, PermissionGroups: body.PermissionGroups}, nil
+}
+
+func (h *Handler) getRole(r *request) (int, any, error) {
+	role, err := h.findRole(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	permissionGroupNames, err := h.svc.GetRolePermissionGroupNames(r.Context(), role.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, roleResponse{Role: role, PermissionGroups: permissionGroupNames}, nil
+}
+
+type updateRoleRequest struct {
+	Version          int64    `json:"version"` // 读取角色时的版本，为 0 时不校验
+	Title            string   `json:"title"`
+	Description      string   `json:"description"`
+	PermissionGroups []string `json:"permission_groups"`
+}
+
+func (h *Handler) updateRole(r *request) (int, any, error) {
+	role, err := h.findRole(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var body updateRoleRequest
+	if err := decodeJSON(r.Request, &body); err != nil {
+		return 0, nil, err
+	}
+	if err := validateRoleFields(body.Title, body.PermissionGroups); err != nil {
+		return 0, nil, err
+	}
+
+	role, err = h.svc.UpdateRoleAs(r.Context(), r.userID, permission.UpdateRoleParam{
+		ID:               role.ID,
+		Version:          body.Version,
+		Title:            body.Title,
+		Description:      body.Description,
+		PermissionGroups: body.PermissionGroups,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, roleResponse{Role: role, PermissionGroups: body.PermissionGroups}, nil
+}
+
+func (h *Handler) deleteRole(r *request) (int, any, error) {
+	role, err := h.findRole(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := h.svc.DeleteRoleAs(r.Context(), r.userID, role.ID); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+type cloneRoleRequest struct {
+	Name                    string   `json:"name"`
+	Title                   string   `json:"title"`
+	Description             string   `json:"description"`
+	ExcludePermissionGroups []string `json:"exclude_permission_groups"`
+}
+
+func (h *Handler) cloneRole(r *request) (int, any, error) {
+	role, err := h.findRole(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var body cloneRoleRequest
+	if err := decodeJSON(r.Request, &body); err != nil {
+		return 0, nil, err
+	}
+	if !roleNameRegexp.MatchString(body.Name) {
+		return 0, nil, invalidArgument("name must match %s", roleNameRegexp.String())
+	}
+
+	cloned, err := h.svc.CloneRoleAs(r.Context(), r.userID, permission.CloneRoleParam{
+		RoleID:                  role.ID,
+		Name:                    body.Name,
+		Title:                   body.Title,
+		Description:             body.Description,
+		ExcludePermissionGroups: body.ExcludePermissionGroups,
+		CreatorUserID:           r.userID,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	permissionGroupNames, err := h.svc.GetRolePermissionGroupNames(r.Context(), cloned.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, roleResponse{Role: cloned, PermissionGroups: permissionGroupNames}, nil
+}
+
+func (h *Handler) getDeletedRoles(r *request) (int, any, error) {
+	roles, err := h.svc.GetDeletedRoles(r.Context(), r.roleableID, r.roleableType)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, rolesResponse{Roles: roles}, nil
+}
+
+func (h *Handler) restoreRole(r *request) (int, any, error) {
+	roleID, err := parseID(r.Request, "role_id")
+	if err != nil {
+		return 0, nil, err
+	}
+	roles, err := h.svc.GetDeletedRoles(r.Context(), r.roleableID, r.roleableType)
+	if err != nil {
+		return 0, nil, err
+	}
+	var found bool
+	for _, role := range roles {
+		if role.ID == roleID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, nil, fmt.Errorf("%w: deleted role id %d not found in %s:%d", permission.ErrRoleNotFound, roleID, r.roleableType, r.roleableID)
+	}
+
+	role, err := h.svc.RestoreRoleAs(r.Context(), r.userID, roleID)
+	if err != nil {
+		return 0, nil, err
+	}
+	permissionGroupNames, err := h.svc.GetRolePermissionGroupNames(r.Context(), role.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, roleResponse{Role: role, PermissionGroups: permissionGroupNames}, nil
+}
+
+func (h *Handler) getUserRoles(r *request) (int, any, error) {
+	userID, err := parseID(r.Request, "user_id")
+	if err != nil {
+		return 0, nil, err
+	}
+	return h.userRoles(r, userID)
+}
+
+type assignRolesToUserRequest struct {
+	RoleIDs []int64 `json:"role_ids"`
+	Version string  `json:"version"` // 获取用户角色时返回的版本，为空时不校验
+}
+
+func (h *Handler) assignRolesToUser(r *request) (int, any, error) {
+	userID, err := parseID(r.Request, "user_id")
+	if err != nil {
+		return 0, nil, err
+	}
+	var body assignRolesToUserRequest
+	if err := decodeJSON(r.Request, &body); err != nil {
+		return 0, nil, err
+	}
+	if body.RoleIDs == nil {
+		return 0, nil, invalidArgument("role_ids is required")
+	}
+
+	if err := h.svc.AssignRolesToUserAs(r.Context(), r.userID, permission.AssignRolesToUserParam{
+		UserID:       userID,
+		RoleableType: r.roleableType,
+		RoleableID:   r.roleableID,
+		RoleIDs:      body.RoleIDs,
+		Version:      body.Version,
+	}); err != nil {
+		return 0, nil, err
+	}
+	return h.userRoles(r, userID)
+}
+
+func (h *Handler) addUserRole(r *request) (int, any, error) {
+	userID, roleID, err := parseUserRoleID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := h.svc.AddUserRolesAs(r.Context(), r.userID, permission.ChangeUserRolesParam{
+		UserID:       userID,
+		RoleableType: r.roleableType,
+		RoleableID:   r.roleableID,
+		RoleIDs:      []int64{roleID},
+	}); err != nil {
+		return 0, nil, err
+	}
+	return h.userRoles(r, userID)
+}
+
+func (h *Handler) removeUserRole(r *request) (int, any, error) {
+	userID, roleID, err := parseUserRoleID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := h.svc.RemoveUserRolesAs(r.Context(), r.userID, permission.ChangeUserRolesParam{
+		UserID:       userID,
+		RoleableType: r.roleableType,
+		RoleableID:   r.roleableID,
+		RoleIDs:      []int64{roleID},
+	}); err != nil {
+		return 0, nil, err
+	}
+	return h.userRoles(r, userID)
+}
+
+func parseUserRoleID(r *request) (int64, int64, error) {
+	userID, err := parseID(r.Request, "user_id")
+	if err != nil {
+		return 0, 0, err
+	}
+	roleID, err := parseID(r.Request, "role_id")
+	if err != nil {
+		return 0, 0, err
+	}
+	return userID, roleID, nil
+}
+
+func (h *Handler) userRoles(r *request, userID int64) (int, any, error) {
+	roles, err := h.svc.GetUserRoles(r.Context(), userID, r.roleableID, r.roleableType, requestLocale(r.Request))
+	if err != nil {
+		return 0, nil, err
+	}
+	version, err := h.svc.GetUserRolesVersion(r.Context(), userID, r.roleableID, r.roleableType)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, userRolesResponse{Roles: roles, Version: version}, nil
+}
+
+func (h *Handler) getPermissionGroupTree(r *request) (int, any, error) {
+	domain := r.URL.Query().Get("domain")
+	var tree []*permission.PermissionGroupItem
+	var err error
+	if r.URL.Query().Get("with_permissions") == "true" {
+		tree, err = h.svc.BuildFullPermissionGroupTreeWithPermissions(r.Context(), domain, requestLocale(r.Request))
+	} else {
+		tree, err = h.svc.BuildFullPermissionGroupTree(r.Context(), domain, requestLocale(r.Request))
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, permissionGroupTreeResponse{PermissionGroups: tree}, nil
+}
+
+// 请求的语言，优先使用 ?locale=，否则使用 Accept-Language 的第一个语言
+func requestLocale(r *http.Request) string {
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		return locale
+	}
+	locale, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	locale, _, _ = strings.Cut(locale, ";")
+	if locale == "*" {
+		return ""
+	}
+	return strings.TrimSpace(locale)
+}
+
+// 获取路由中的角色，并确认角色属于路由中的对象
+func (h *Handler) findRole(r *request) (*permission.Role, error) {
+	roleID, err := parseID(r.Request, "role_id")
+	if err != nil {
+		return nil, err
+	}
+	role, err := h.svc.GetRole(r.Context(), roleID)
+	if err != nil {
+		return nil, err
+	}
+	if role.RoleableType != r.roleableType || role.RoleableID != r.roleableID {
+		return nil, fmt.Errorf("%w: role id %d not found in %s:%d", permission.ErrRoleNotFound, roleID, r.roleableType, r.roleableID)
+	}
+	return role, nil
+}
+
+func validateRoleFields(title string, permissionGroups []string) error {
+	if title == "" {
+		return invalidArgument("title is required")
+	}
+	if len(permissionGroups) == 0 {
+		return invalidArgument("permission_groups is required")
+	}
+	permissionGroupsMap := make(map[string]struct{}, len(permissionGroups))
+	for _, name := range permissionGroups {
+		if name == "" {
+			return invalidArgument("permission_groups contains empty name")
+		}
+		if _, ok := permissionGroupsMap[name]; ok {
+			return invalidArgument("permission_groups contains reduplicated name %s", name)
+		}
+		permissionGroupsMap[name] = struct{}{}
+	}
+	return nil
+}
+
+func parseID(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, invalidArgument("invalid %s", name)
+	}
+	return id, nil
+}
+
+func parseQueryInt(value, name string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, invalidArgument("invalid %s", name)
+	}
+	return n, nil
+}
+
+func decodeJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return invalidArgument("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	if v == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// 将错误转换为统一的接口错误
+func toError(err error) *Error {
+	var apiErr *Error
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, permission.ErrRoleNotFound):
+		return &Error{Status: http.StatusNotFound, Code: CodeNotFound, Message: err.Error()}
+	case errors.Is(err, permission.ErrInvalidArgument), errors.Is(err, permission.ErrPermissionGroupNotFound), errors.Is(err, permission.ErrEmptyPermissionGroups):
+		return &Error{Status: http.StatusBadRequest, Code: CodeInvalidArgument, Message: err.Error()}
+	case errors.Is(err, permission.ErrRoleAlreadyExists), errors.Is(err, permission.ErrVersionConflict):
+		return &Error{Status: http.StatusConflict, Code: CodeConflict, Message: err.Error()}
+	case errors.Is(err, permission.ErrPrivilegeEscalation):
+		return &Error{Status: http.StatusForbidden, Code: CodePermissionDenied, Message: err.Error()}
+	case errors.Is(err, permission.ErrMinHoldersViolated), errors.Is(err, permission.ErrRoleConstraintViolated):
+		return &Error{Status: http.StatusConflict, Code: CodeFailedPrecondition, Message: err.Error()}
+	default:
+		return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "internal error"}
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	apiErr := toError(err)
+	writeJSON(w, apiErr.Status, apiErr)
+}
@@ -0,0 +1,151 @@
+package adminapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"git.sofunny.io/data-analysis/gotools/permission"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *permission.PermissionService) {
+	t.Helper()
+
+	content, err := os.ReadFile("../examples/metadata.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metadata permission.PermissionMetadata
+	if err := yaml.Unmarshal(content, &metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "permission.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := permission.New(db, &metadata, permission.WithMinHoldersRules(permission.MinHoldersRule{
+		RoleableType: "app",
+		RoleNames:    []string{"admin"},
+		MinHolders:   1,
+	}))
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPresetRoles(db, 1, "app"); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := svc.GetRoles(ctx, 1, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 用户 1 是应用 1 的管理员
+	if err := svc.AssignRolesToUser(ctx, permission.AssignRolesToUserParam{
+		UserID:       1,
+		RoleableType: "app",
+		RoleableID:   1,
+		RoleIDs:      []int64{roles[0].ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	userIDFunc := func(r *http.Request) (int64, error) {
+		return strconv.ParseInt(r.Header.Get("X-User-ID"), 10, 64)
+	}
+	return New(svc, userIDFunc, WithManagePermissionGroup("app-manage")), svc
+}
+
+func TestHandler(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	tests := []struct {
+		name       string
+		userID     string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "unauthenticated", method: http.MethodGet, path: "/roleables/app/1/roles", wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthenticated},
+		{name: "permission denied", userID: "2", method: http.MethodGet, path: "/roleables/app/1/roles", wantStatus: http.StatusForbidden, wantCode: CodePermissionDenied},
+		{name: "other roleable denied", userID: "1", method: http.MethodGet, path: "/roleables/app/2/roles", wantStatus: http.StatusForbidden, wantCode: CodePermissionDenied},
+		{name: "invalid roleable id", userID: "1", method: http.MethodGet, path: "/roleables/app/abc/roles", wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArgument},
+		{name: "get roles", userID: "1", method: http.MethodGet, path: "/roleables/app/1/roles", wantStatus: http.StatusOK},
+		{name: "query roles", userID: "1", method: http.MethodGet, path: "/roleables/app/1/roles?keyword=adm&kind=preset&order_by=member_count&desc=true&limit=10", wantStatus: http.StatusOK},
+		{name: "query roles invalid order by", userID: "1", method: http.MethodGet, path: "/roleables/app/1/roles?order_by=x", wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArgument},
+		{name: "query roles invalid limit", userID: "1", method: http.MethodGet, path: "/roleables/app/1/roles?limit=x", wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArgument},
+		{name: "create role invalid name", userID: "1", method: http.MethodPost, path: "/roleables/app/1/roles", body: `{"name":"","title":"编辑","permission_groups":["app-post-manage"]}`, wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArgument},
+		{name: "create role unknown field", userID: "1", method: http.MethodPost, path: "/roleables/app/1/roles", body: `{"name":"editor","title":"编辑","permission_groups":["app-post-manage"],"x":1}`, wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArgument},
+		{name: "create role unknown permission group", userID: "1", method: http.MethodPost, path: "/roleables/app/1/roles", body: `{"name":"editor","title":"编辑","permission_groups":["not-existed"]}`, wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArgument},
+		{name: "create role", userID: "1", method: http.MethodPost, path: "/roleables/app/1/roles", body: `{"name":"editor","title":"编辑","permission_groups":["app-post-manage"]}`, wantStatus: http.StatusCreated},
+		{name: "create role existed name", userID: "1", method: http.MethodPost, path: "/roleables/app/1/roles", body: `{"name":"editor","title":"编辑","permission_groups":["app-manage"]}`, wantStatus: http.StatusConflict, wantCode: CodeConflict},
+		{name: "create role preset name", userID: "1", method: http.MethodPost, path: "/roleables/app/1/roles", body: `{"name":"admin","title":"管理员","permission_groups":["app-post-manage"]}`, wantStatus: http.StatusConflict, wantCode: CodeConflict},
+		{name: "get role", userID: "1", method: http.MethodGet, path: "/roleables/app/1/roles/2", wantStatus: http.StatusOK},
+		{name: "get role not found", userID: "1", method: http.MethodGet, path: "/roleables/app/1/roles/100", wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "update role", userID: "1", method: http.MethodPut, path: "/roleables/app/1/roles/2", body: `{"version":1,"title":"编辑者","permission_groups":["app-post-manage"]}`, wantStatus: http.StatusOK},
+		{name: "update role stale version", userID: "1", method: http.MethodPut, path: "/roleables/app/1/roles/2", body: `{"version":1,"title":"编辑","permission_groups":["app-post-manage"]}`, wantStatus: http.StatusConflict, wantCode: CodeConflict},
+		{name: "assign roles to user", userID: "1", method: http.MethodPut, path: "/roleables/app/1/users/3/roles", body: `{"role_ids":[2]}`, wantStatus: http.StatusOK},
+		{name: "assign roles to user stale version", userID: "1", method: http.MethodPut, path: "/roleables/app/1/users/3/roles", body: `{"role_ids":[],"version":"stale"}`, wantStatus: http.StatusConflict, wantCode: CodeConflict},
+		{name: "assign unknown role to user", userID: "1", method: http.MethodPut, path: "/roleables/app/1/users/3/roles", body: `{"role_ids":[100]}`, wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "remove last admin", userID: "1", method: http.MethodPut, path: "/roleables/app/1/users/1/roles", body: `{"role_ids":[]}`, wantStatus: http.StatusConflict, wantCode: CodeFailedPrecondition},
+		{name: "add user role", userID: "1", method: http.MethodPut, path: "/roleables/app/1/users/3/roles/1", wantStatus: http.StatusOK},
+		{name: "remove user role", userID: "1", method: http.MethodDelete, path: "/roleables/app/1/users/3/roles/1", wantStatus: http.StatusOK},
+		{name: "add unknown user role", userID: "1", method: http.MethodPut, path: "/roleables/app/1/users/3/roles/100", wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "get user roles", userID: "1", method: http.MethodGet, path: "/roleables/app/1/users/3/roles", wantStatus: http.StatusOK},
+		{name: "get permission group tree", userID: "1", method: http.MethodGet, path: "/roleables/app/1/permission-group-tree", wantStatus: http.StatusOK},
+		{name: "get permission group tree with permissions", userID: "1", method: http.MethodGet, path: "/roleables/app/1/permission-group-tree?with_permissions=true", wantStatus: http.StatusOK},
+		{name: "clone role", userID: "1", method: http.MethodPost, path: "/roleables/app/1/roles/1/clone", body: `{"name":"admin-copy","exclude_permission_groups":["app-manage"]}`, wantStatus: http.StatusCreated},
+		{name: "clone role existed name", userID: "1", method: http.MethodPost, path: "/roleables/app/1/roles/1/clone", body: `{"name":"editor"}`, wantStatus: http.StatusConflict, wantCode: CodeConflict},
+		{name: "delete role", userID: "1", method: http.MethodDelete, path: "/roleables/app/1/roles/2", wantStatus: http.StatusNoContent},
+		{name: "get deleted role not found", userID: "1", method: http.MethodGet, path: "/roleables/app/1/roles/2", wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "get deleted roles", userID: "1", method: http.MethodGet, path: "/roleables/app/1/deleted-roles", wantStatus: http.StatusOK},
+		{name: "restore role", userID: "1", method: http.MethodPost, path: "/roleables/app/1/deleted-roles/2/restore", wantStatus: http.StatusOK},
+		{name: "restore not deleted role", userID: "1", method: http.MethodPost, path: "/roleables/app/1/deleted-roles/2/restore", wantStatus: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "create manager role", userID: "1", method: http.MethodPost, path: "/roleables/app/1/roles", body: `{"name":"manager","title":"经理","permission_groups":["app-manage"]}`, wantStatus: http.StatusCreated},
+		{name: "assign manager role", userID: "1", method: http.MethodPut, path: "/roleables/app/1/users/4/roles", body: `{"role_ids":[4]}`, wantStatus: http.StatusOK},
+		{name: "create role escalation", userID: "4", method: http.MethodPost, path: "/roleables/app/1/roles", body: `{"name":"poster","title":"发帖","permission_groups":["app-post-manage"]}`, wantStatus: http.StatusForbidden, wantCode: CodePermissionDenied},
+		{name: "remove user role escalation", userID: "4", method: http.MethodDelete, path: "/roleables/app/1/users/3/roles/2", wantStatus: http.StatusForbidden, wantCode: CodePermissionDenied},
+		{name: "remove user role escalation by assign", userID: "4", method: http.MethodPut, path: "/roleables/app/1/users/3/roles", body: `{"role_ids":[]}`, wantStatus: http.StatusForbidden, wantCode: CodePermissionDenied},
+		{name: "assign role escalation", userID: "4", method: http.MethodPut, path: "/roleables/app/1/users/5/roles", body: `{"role_ids":[2]}`, wantStatus: http.StatusForbidden, wantCode: CodePermissionDenied},
+		{name: "delete role escalation", userID: "4", method: http.MethodDelete, path: "/roleables/app/1/roles/2", wantStatus: http.StatusForbidden, wantCode: CodePermissionDenied},
+		{name: "delete role again", userID: "1", method: http.MethodDelete, path: "/roleables/app/1/roles/2", wantStatus: http.StatusNoContent},
+		{name: "restore role escalation", userID: "4", method: http.MethodPost, path: "/roleables/app/1/deleted-roles/2/restore", wantStatus: http.StatusForbidden, wantCode: CodePermissionDenied},
+		{name: "restore role again", userID: "1", method: http.MethodPost, path: "/roleables/app/1/deleted-roles/2/restore", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("X-User-ID", tt.userID)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				var apiErr Error
+				if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil {
+					t.Fatal(err)
+				}
+				if apiErr.Code != tt.wantCode {
+					t.Errorf("code = %s, want %s", apiErr.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
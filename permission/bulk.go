@@ -0,0 +1,200 @@
+package permission
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 批量写入的默认批次大小
+const defaultBulkBatchSize = 500
+
+type BulkUserRolesItem struct {
+	UserID  int64   `json:"user_id" yaml:"user_id"`
+	RoleIDs []int64 `json:"role_ids" yaml:"role_ids"`
+}
+
+type BulkAssignRolesParam struct {
+	RoleableType string               `json:"roleable_type" yaml:"roleable_type"`
+	RoleableID   int64                `json:"roleable_id" yaml:"roleable_id"`
+	Users        []*BulkUserRolesItem `json:"users" yaml:"users"`
+	Replace      bool                 `json:"replace" yaml:"replace"`       // 为 true 时替换用户在对象下的全部角色，同 AssignRolesToUser，否则只添加角色
+	BatchSize    int                  `json:"batch_size" yaml:"batch_size"` // 每批写入的数量，为 0 时使用 500
+}
+
+// 单个用户的批量分配结果
+type BulkAssignRolesResult struct {
+	UserID  int64   `json:"user_id" yaml:"user_id"`
+	Added   []int64 `json:"added" yaml:"added"`     // 新增的角色ID
+	Removed []int64 `json:"removed" yaml:"removed"` // 移除的角色ID
+	Err     error   `json:"-" yaml:"-"`             // 校验失败的原因，失败的用户不会被修改
+}
+
+// 在一个事务中为多个用户分配对象下的角色，返回和 param.Users 顺序一致的结果
+//
+// 角色和职责分离约束统一校验，校验失败的用户记录在结果中并跳过，其他用户照常写入；
+// 数据库错误或持有人数约束失败时整个事务回滚并返回错误
+//
+// 不检查操作者的权限组，只用于管理员或后台任务
+func (s *PermissionService) BulkAssignRoles(ctx context.Context, param BulkAssignRolesParam) (_ []*BulkAssignRolesResult, err error) {
+	defer s.observe(ctx, "BulkAssignRoles", time.Now(), &err)
+	batchSize := param.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBulkBatchSize
+	}
+
+	results := make([]*BulkAssignRolesResult, 0, len(param.Users))
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		var roles []*Role
+		if err := tx.Scopes(notDeletedRole).
+			Where("roleable_type = ?", param.RoleableType).
+			Where("roleable_id = ?", param.RoleableID).
+			Find(&roles).Error; err != nil {
+			return err
+		}
+		rolesMap := make(map[int64]*Role, len(roles))
+		roleIDs := make([]int64, 0, len(roles))
+		for _, r := range roles {
+			rolesMap[r.ID] = r
+			roleIDs = append(roleIDs, r.ID)
+		}
+
+		userIDs := make([]int64, 0, len(param.Users))
+		for _, u := range param.Users {
+			userIDs = append(userIDs, u.UserID)
+		}
+		existedRoleIDsMap := make(map[int64][]int64, len(param.Users))
+		if len(roleIDs) > 0 {
+			for chunk := range slices.Chunk(userIDs, batchSize) {
+				var userRoles []*UserRole
+				if err := tx.Where("user_id IN ?", chunk).Where("role_id IN ?", roleIDs).Order("role_id").Find(&userRoles).Error; err != nil {
+					return err
+				}
+				for _, ur := range userRoles {
+					existedRoleIDsMap[ur.UserID] = append(existedRoleIDsMap[ur.UserID], ur.RoleID)
+				}
+			}
+		}
+
+		guard, err := s.newMinHoldersGuard(tx, param.RoleableType, param.RoleableID)
+		if err != nil {
+			return err
+		}
+
+		var addUserRoles []*UserRole
+		var removeUserIDs []int64
+		var changedSnapshots [][2]*UserRolesSnapshot
+		var changedUserIDs []int64
+		seenUserIDsMap := make(map[int64]struct{}, len(param.Users))
+		for _, u := range param.Users {
+			result := &BulkAssignRolesResult{UserID: u.UserID}
+			results = append(results, result)
+
+			if _, ok := seenUserIDsMap[u.UserID]; ok {
+				result.Err = fmt.Errorf("%w: user id %d reduplicated", ErrInvalidArgument, u.UserID)
+				continue
+			}
+			seenUserIDsMap[u.UserID] = struct{}{}
+
+			existedRoleIDs := existedRoleIDsMap[u.UserID]
+			afterRoleIDs := slices.Clone(u.RoleIDs)
+			if !param.Replace {
+				afterRoleIDs = append(afterRoleIDs, existedRoleIDs...)
+			}
+			slices.Sort(afterRoleIDs)
+			afterRoleIDs = slices.Compact(afterRoleIDs)
+
+			if result.Err = s.validateBulkUserRoles(u.UserID, param.RoleableType, param.RoleableID, afterRoleIDs, rolesMap); result.Err != nil {
+				continue
+			}
+
+			for _, roleID := range afterRoleIDs {
+				if !slices.Contains(existedRoleIDs, roleID) {
+					result.Added = append(result.Added, roleID)
+				}
+			}
+			for _, roleID := range existedRoleIDs {
+				if !slices.Contains(afterRoleIDs, roleID) {
+					result.Removed = append(result.Removed, roleID)
+				}
+			}
+			// 有角色被移除时先删除用户在对象下的全部角色，再写入分配后的全部角色
+			insertRoleIDs := result.Added
+			if len(result.Removed) > 0 {
+				removeUserIDs = append(removeUserIDs, u.UserID)
+				insertRoleIDs = afterRoleIDs
+			}
+			for _, roleID := range insertRoleIDs {
+				addUserRoles = append(addUserRoles, &UserRole{UserID: u.UserID, RoleID: roleID})
+			}
+			if len(result.Added) > 0 || len(result.Removed) > 0 {
+				changedUserIDs = append(changedUserIDs, u.UserID)
+				changedSnapshots = append(changedSnapshots, [2]*UserRolesSnapshot{
+					{UserID: u.UserID, RoleableType: param.RoleableType, RoleableID: param.RoleableID, RoleIDs: existedRoleIDs},
+					{UserID: u.UserID, RoleableType: param.RoleableType, RoleableID: param.RoleableID, RoleIDs: afterRoleIDs},
+				})
+			}
+		}
+
+		for chunk := range slices.Chunk(removeUserIDs, batchSize) {
+			if err := tx.Where("user_id IN ?", chunk).Where("role_id IN ?", roleIDs).Delete(&UserRole{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(addUserRoles) > 0 {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}, {Name: "role_id"}},
+				DoNothing: true,
+			}).CreateInBatches(addUserRoles, batchSize).Error; err != nil {
+				return err
+			}
+		}
+		if err := s.bumpUserRolesVersions(tx, param.RoleableType, param.RoleableID, changedUserIDs...); err != nil {
+			return err
+		}
+
+		if err := guard.check(tx); err != nil {
+			return err
+		}
+		if !s.hasHooks() {
+			return nil
+		}
+		for _, snapshots := range changedSnapshots {
+			if err := emit(&Event{Type: EventUserRolesChanged, Before: snapshots[0], After: snapshots[1]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// 校验用户分配后的角色都属于对象并满足职责分离约束
+func (s *PermissionService) validateBulkUserRoles(userID int64, roleableType string, roleableID int64, roleIDs []int64, rolesMap map[int64]*Role) error {
+	roleNames := make([]string, 0, len(roleIDs))
+	for _, roleID := range roleIDs {
+		role, ok := rolesMap[roleID]
+		if !ok {
+			return fmt.Errorf("%w: role id %d not found in %s:%d", ErrRoleNotFound, roleID, roleableType, roleableID)
+		}
+		roleNames = append(roleNames, role.Name)
+	}
+	slices.Sort(roleNames)
+	for _, c := range s.roleConstraints(roleableType) {
+		if violated := c.violatedRoleNames(roleNames); violated != nil {
+			return &RoleConstraintError{
+				Constraint: *c,
+				UserID:     userID,
+				RoleableID: roleableID,
+				RoleNames:  violated,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,523 @@
+package permission
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// casbin RBAC with domains 策略和本包数据的对应关系
+//
+//	p, <role.name>, <role.roleable_type>:<role.roleable_id>, <permission.resource>, <permission.action>
+//	g, <user_role.user_id>, <role.name>, <role.roleable_type>:<role.roleable_id>
+//	g2, <role.name>, <role_permission_group.permission_group_name>, <role.roleable_type>:<role.roleable_id>
+//
+// 权限 domain 不为空时，obj 格式为 <permission.domain>::<permission.resource>，
+// g2 记录角色拥有的权限组，用于还原没有权限的权限组（比如菜单、父权限组），casbin 模型中对应 g2 = _, _, _
+const (
+	CasbinPolicyTypeP  = "p"
+	CasbinPolicyTypeG  = "g"
+	CasbinPolicyTypeG2 = "g2"
+
+	casbinObjectDomainSeparator = "::"
+)
+
+// casbin 策略，对应 CSV 中的一行
+type CasbinPolicy struct {
+	PolicyType string
+	Values     []string
+}
+
+// 读取 casbin 策略 CSV，忽略空行和 # 开头的注释
+func ParseCasbinPolicies(r io.Reader) ([]*CasbinPolicy, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	var policies []*CasbinPolicy
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		line, _ := reader.FieldPos(0)
+		switch record[0] {
+		case CasbinPolicyTypeP:
+			if len(record) != 5 {
+				return nil, fmt.Errorf("casbin policy line %d: p policy must be p, sub, dom, obj, act", line)
+			}
+		case CasbinPolicyTypeG:
+			if len(record) != 4 {
+				return nil, fmt.Errorf("casbin policy line %d: g policy must be g, user, role, dom", line)
+			}
+		case CasbinPolicyTypeG2:
+			if len(record) != 4 {
+				return nil, fmt.Errorf("casbin policy line %d: g2 policy must be g2, role, permission_group, dom", line)
+			}
+		default:
+			return nil, fmt.Errorf("casbin policy line %d: unsupported policy type %s", line, record[0])
+		}
+		policies = append(policies, &CasbinPolicy{
+			PolicyType: record[0],
+			Values:     record[1:],
+		})
+	}
+	return policies, nil
+}
+
+// 输出 casbin 策略 CSV
+func WriteCasbinPolicies(w io.Writer, policies []*CasbinPolicy) error {
+	writer := csv.NewWriter(w)
+	for _, p := range policies {
+		if err := writer.Write(append([]string{p.PolicyType}, p.Values...)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func casbinDomain(roleableType string, roleableID int64) string {
+	return fmt.Sprintf("%s:%d", roleableType, roleableID)
+}
+
+func parseCasbinDomain(dom string) (string, int64, error) {
+	i := strings.LastIndex(dom, ":")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("casbin domain %s must be roleable_type:roleable_id", dom)
+	}
+	roleableID, err := strconv.ParseInt(dom[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("casbin domain %s must be roleable_type:roleable_id", dom)
+	}
+	return dom[:i], roleableID, nil
+}
+
+func casbinObject(domain, resource string) string {
+	if domain == "" {
+		return resource
+	}
+	return domain + casbinObjectDomainSeparator + resource
+}
+
+func parseCasbinObject(obj string) (string, string) {
+	if domain, resource, ok := strings.Cut(obj, casbinObjectDomainSeparator); ok {
+		return domain, resource
+	}
+	return "", obj
+}
+
+type ExportCasbinPoliciesParam struct {
+	RoleableType         string `json:"roleable_type" yaml:"roleable_type"`                   // 为空时导出所有角色
+	WithPermissionGroups bool   `json:"with_permission_groups" yaml:"with_permission_groups"` // 是否同时导出角色权限组 g2 策略，导入时按 g2 精确还原角色权限组
+}
+
+// 将角色权限和用户角色导出为 casbin 策略
+func (s *PermissionService) ExportCasbinPolicies(ctx context.Context, param ExportCasbinPoliciesParam) ([]*CasbinPolicy, error) {
+	db := s.db.WithContext(ctx)
+
+	var roles []*Role
+	query := db.Model(&Role{}).Scopes(notDeletedRole)
+	if param.RoleableType != "" {
+		query = query.Where("roleable_type = ?", param.RoleableType)
+	}
+	if err := query.Order("roleable_type").Order("roleable_id").Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	rolesMap := make(map[int64]*Role, len(roles))
+	roleIDs := make([]int64, 0, len(roles))
+	for _, r := range roles {
+		rolesMap[r.ID] = r
+		roleIDs = append(roleIDs, r.ID)
+	}
+
+	var rolePermissionGroups []*RolePermissionGroup
+	if err := db.Where("role_id IN ?", roleIDs).Order("permission_group_name").Find(&rolePermissionGroups).Error; err != nil {
+		return nil, err
+	}
+	rolePermissionGroupNamesMap := make(map[int64][]string, len(roles))
+	for _, g := range rolePermissionGroups {
+		rolePermissionGroupNamesMap[g.RoleID] = append(rolePermissionGroupNamesMap[g.RoleID], g.PermissionGroupName)
+	}
+
+	var permissionGroupPermissions []*PermissionGroupPermission
+	if err := db.Find(&permissionGroupPermissions).Error; err != nil {
+		return nil, err
+	}
+	groupPermissionNamesMap := make(map[string][]string)
+	for _, p := range permissionGroupPermissions {
+		groupPermissionNamesMap[p.PermissionGroupName] = append(groupPermissionNamesMap[p.PermissionGroupName], p.PermissionName)
+	}
+
+	var permissions []*Permission
+	if err := db.Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	permissionsMap := make(map[string]*Permission, len(permissions))
+	for _, p := range permissions {
+		permissionsMap[p.Name] = p
+	}
+
+	var policies []*CasbinPolicy
+	for _, role := range roles {
+		dom := casbinDomain(role.RoleableType, role.RoleableID)
+		var rolePermissions []*Permission
+		rolePermissionKeysMap := make(map[string]struct{})
+		for _, groupName := range rolePermissionGroupNamesMap[role.ID] {
+			for _, permissionName := range groupPermissionNamesMap[groupName] {
+				p, ok := permissionsMap[permissionName]
+				if !ok {
+					continue
+				}
+				if _, ok := rolePermissionKeysMap[p.Name]; ok {
+					continue
+				}
+				rolePermissionKeysMap[p.Name] = struct{}{}
+				rolePermissions = append(rolePermissions, p)
+			}
+		}
+		sort.Slice(rolePermissions, func(i, j int) bool {
+			a, b := rolePermissions[i], rolePermissions[j]
+			if a.Domain != b.Domain {
+				return a.Domain < b.Domain
+			}
+			if a.Resource != b.Resource {
+				return a.Resource < b.Resource
+			}
+			return a.Action < b.Action
+		})
+		for _, p := range rolePermissions {
+			policies = append(policies, &CasbinPolicy{
+				PolicyType: CasbinPolicyTypeP,
+				Values:     []string{role.Name, dom, casbinObject(p.Domain, p.Resource), p.Action},
+			})
+		}
+		if param.WithPermissionGroups {
+			for _, groupName := range rolePermissionGroupNamesMap[role.ID] {
+				policies = append(policies, &CasbinPolicy{
+					PolicyType: CasbinPolicyTypeG2,
+					Values:     []string{role.Name, groupName, dom},
+				})
+			}
+		}
+	}
+
+	var userRoles []*UserRole
+	if err := db.Where("role_id IN ?", roleIDs).Order("user_id").Order("role_id").Find(&userRoles).Error; err != nil {
+		return nil, err
+	}
+	for _, ur := range userRoles {
+		role := rolesMap[ur.RoleID]
+		policies = append(policies, &CasbinPolicy{
+			PolicyType: CasbinPolicyTypeG,
+			Values:     []string{strconv.FormatInt(ur.UserID, 10), role.Name, casbinDomain(role.RoleableType, role.RoleableID)},
+		})
+	}
+	return policies, nil
+}
+
+type ImportCasbinPoliciesResult struct {
+	Roles     []*Role     `json:"roles" yaml:"roles"`
+	UserRoles []*UserRole `json:"user_roles" yaml:"user_roles"`
+}
+
+type casbinRoleKey struct {
+	roleableType string
+	roleableID   int64
+	name         string
+}
+
+// 将 casbin 策略导入为角色和用户角色
+//
+// 策略中的权限需要已通过 SyncPermissionMetadata 同步，每个角色的权限需要能由若干完整的权限组精确组成，
+// 否则返回错误且不修改数据库。角色有 g2 策略时使用 g2 中的权限组，这些权限组的权限需要和 p 策略一致。
+// 导入只会新增用户角色，不会移除用户已有角色
+func (s *PermissionService) ImportCasbinPolicies(ctx context.Context, policies []*CasbinPolicy) (_ *ImportCasbinPoliciesResult, err error) {
+	defer s.observe(ctx, "ImportCasbinPolicies", time.Now(), &err)
+	db := s.db.WithContext(ctx)
+
+	var permissions []*Permission
+	if err := db.Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	permissionNamesMap := make(map[string]string, len(permissions))
+	for _, p := range permissions {
+		permissionNamesMap[fmt.Sprintf("%s_%s_%s", p.Domain, p.Resource, p.Action)] = p.Name
+	}
+
+	var permissionGroupPermissions []*PermissionGroupPermission
+	if err := db.Find(&permissionGroupPermissions).Error; err != nil {
+		return nil, err
+	}
+	groupPermissionNamesMap := make(map[string][]string)
+	for _, p := range permissionGroupPermissions {
+		groupPermissionNamesMap[p.PermissionGroupName] = append(groupPermissionNamesMap[p.PermissionGroupName], p.PermissionName)
+	}
+
+	var permissionGroupNames []string
+	if err := db.Model(&PermissionGroup{}).Pluck("name", &permissionGroupNames).Error; err != nil {
+		return nil, err
+	}
+	permissionGroupNamesMap := make(map[string]struct{}, len(permissionGroupNames))
+	for _, name := range permissionGroupNames {
+		permissionGroupNamesMap[name] = struct{}{}
+	}
+
+	var roleKeys []casbinRoleKey
+	rolePermissionNamesMap := make(map[casbinRoleKey]map[string]struct{})
+	addRoleKey := func(key casbinRoleKey) {
+		if _, ok := rolePermissionNamesMap[key]; !ok {
+			roleKeys = append(roleKeys, key)
+			rolePermissionNamesMap[key] = make(map[string]struct{})
+		}
+	}
+	// g2 策略中角色的权限组
+	linkedPermissionGroupNamesMap := make(map[casbinRoleKey]map[string]struct{})
+	var userRoleKeys []casbinRoleKey
+	var userIDs []int64
+	for _, p := range policies {
+		switch p.PolicyType {
+		case CasbinPolicyTypeP:
+			roleableType, roleableID, err := parseCasbinDomain(p.Values[1])
+			if err != nil {
+				return nil, err
+			}
+			key := casbinRoleKey{roleableType: roleableType, roleableID: roleableID, name: p.Values[0]}
+			addRoleKey(key)
+			domain, resource := parseCasbinObject(p.Values[2])
+			permissionName, ok := permissionNamesMap[fmt.Sprintf("%s_%s_%s", domain, resource, p.Values[3])]
+			if !ok {
+				return nil, fmt.Errorf("casbin policy %s: permission domain:%s + resource:%s + action:%s not found", strings.Join(p.Values, ", "), domain, resource, p.Values[3])
+			}
+			rolePermissionNamesMap[key][permissionName] = struct{}{}
+		case CasbinPolicyTypeG2:
+			roleableType, roleableID, err := parseCasbinDomain(p.Values[2])
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := permissionGroupNamesMap[p.Values[1]]; !ok {
+				return nil, fmt.Errorf("casbin policy %s: %w: %s", strings.Join(p.Values, ", "), ErrPermissionGroupNotFound, p.Values[1])
+			}
+			key := casbinRoleKey{roleableType: roleableType, roleableID: roleableID, name: p.Values[0]}
+			addRoleKey(key)
+			if _, ok := linkedPermissionGroupNamesMap[key]; !ok {
+				linkedPermissionGroupNamesMap[key] = make(map[string]struct{})
+			}
+			linkedPermissionGroupNamesMap[key][p.Values[1]] = struct{}{}
+		case CasbinPolicyTypeG:
+			userID, err := strconv.ParseInt(p.Values[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("casbin policy %s: user must be user id, role inheritance is not supported", strings.Join(p.Values, ", "))
+			}
+			roleableType, roleableID, err := parseCasbinDomain(p.Values[2])
+			if err != nil {
+				return nil, err
+			}
+			userRoleKeys = append(userRoleKeys, casbinRoleKey{roleableType: roleableType, roleableID: roleableID, name: p.Values[1]})
+			userIDs = append(userIDs, userID)
+		default:
+			return nil, fmt.Errorf("unsupported casbin policy type %s", p.PolicyType)
+		}
+	}
+
+	// 有 g2 策略时使用其中的权限组，这些权限组的权限不能超出角色权限；
+	// 否则选择权限完全包含在角色权限内的权限组。最后确认这些权限组能覆盖角色的所有权限
+	rolePermissionGroupNamesMap := make(map[casbinRoleKey][]string, len(roleKeys))
+	for _, key := range roleKeys {
+		rolePermissionNames := rolePermissionNamesMap[key]
+		coveredPermissionNamesMap := make(map[string]struct{}, len(rolePermissionNames))
+		var groupNames []string
+		if linkedGroupNamesMap, ok := linkedPermissionGroupNamesMap[key]; ok {
+			extraPermissionNamesMap := make(map[string]struct{})
+			for groupName := range linkedGroupNamesMap {
+				groupNames = append(groupNames, groupName)
+				for _, name := range groupPermissionNamesMap[groupName] {
+					if _, ok := rolePermissionNames[name]; !ok {
+						extraPermissionNamesMap[name] = struct{}{}
+					}
+					coveredPermissionNamesMap[name] = struct{}{}
+				}
+			}
+			if len(extraPermissionNamesMap) > 0 {
+				extraPermissionNames := make([]string, 0, len(extraPermissionNamesMap))
+				for name := range extraPermissionNamesMap {
+					extraPermissionNames = append(extraPermissionNames, name)
+				}
+				sort.Strings(extraPermissionNames)
+				return nil, fmt.Errorf("casbin role %s in %s: permissions %v of g2 permission groups not in p policies", key.name, casbinDomain(key.roleableType, key.roleableID), extraPermissionNames)
+			}
+		} else {
+			for groupName, permissionNames := range groupPermissionNamesMap {
+				contained := len(permissionNames) > 0
+				for _, name := range permissionNames {
+					if _, ok := rolePermissionNames[name]; !ok {
+						contained = false
+						break
+					}
+				}
+				if !contained {
+					continue
+				}
+				groupNames = append(groupNames, groupName)
+				for _, name := range permissionNames {
+					coveredPermissionNamesMap[name] = struct{}{}
+				}
+			}
+		}
+		var uncoveredPermissionNames []string
+		for name := range rolePermissionNames {
+			if _, ok := coveredPermissionNamesMap[name]; !ok {
+				uncoveredPermissionNames = append(uncoveredPermissionNames, name)
+			}
+		}
+		if len(uncoveredPermissionNames) > 0 {
+			sort.Strings(uncoveredPermissionNames)
+			return nil, fmt.Errorf("casbin role %s in %s: permissions %v not covered by any permission group", key.name, casbinDomain(key.roleableType, key.roleableID), uncoveredPermissionNames)
+		}
+		sort.Strings(groupNames)
+		rolePermissionGroupNamesMap[key] = groupNames
+	}
+
+	var result ImportCasbinPoliciesResult
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		// 记录导入前用户在各对象下的角色
+		type userRoleableKey struct {
+			userID       int64
+			roleableType string
+			roleableID   int64
+		}
+		var userRolesBefore []*UserRolesSnapshot
+		userRolesBeforeMap := make(map[userRoleableKey]struct{}, len(userRoleKeys))
+		for i, key := range userRoleKeys {
+			userKey := userRoleableKey{userID: userIDs[i], roleableType: key.roleableType, roleableID: key.roleableID}
+			if _, ok := userRolesBeforeMap[userKey]; ok {
+				continue
+			}
+			userRolesBeforeMap[userKey] = struct{}{}
+			snapshot, err := s.userRolesSnapshot(tx, userIDs[i], key.roleableID, key.roleableType)
+			if err != nil {
+				return err
+			}
+			userRolesBefore = append(userRolesBefore, snapshot)
+		}
+
+		// 已有角色的权限组会被替换
+		var guards []*minHoldersGuard
+		guardsMap := make(map[string]struct{})
+		for _, key := range roleKeys {
+			domain := casbinDomain(key.roleableType, key.roleableID)
+			if _, ok := guardsMap[domain]; ok {
+				continue
+			}
+			guardsMap[domain] = struct{}{}
+			guard, err := s.newMinHoldersGuard(tx, key.roleableType, key.roleableID)
+			if err != nil {
+				return err
+			}
+			guards = append(guards, guard)
+		}
+
+		rolesMap := make(map[casbinRoleKey]*Role, len(roleKeys))
+		for _, key := range roleKeys {
+			var before *RoleSnapshot
+			if s.hasHooks() {
+				var err error
+				if before, err = s.roleSnapshotByName(tx, key.roleableType, key.roleableID, key.name); err != nil {
+					return err
+				}
+			}
+			role := &Role{
+				RoleableType: key.roleableType,
+				RoleableID:   key.roleableID,
+				Name:         key.name,
+				Title:        key.name,
+			}
+			if err := tx.Scopes(notDeletedRole).FirstOrCreate(role, &Role{
+				RoleableType: key.roleableType,
+				RoleableID:   key.roleableID,
+				Name:         key.name,
+			}).Error; err != nil {
+				return err
+			}
+			if err := s.assignPermissionGroupsToRole(tx, role.ID, rolePermissionGroupNamesMap[key]); err != nil {
+				return err
+			}
+			if err := s.emitRoleSaved(tx, emit, before, role.ID); err != nil {
+				return err
+			}
+			rolesMap[key] = role
+			result.Roles = append(result.Roles, role)
+		}
+
+		for i, key := range userRoleKeys {
+			role, ok := rolesMap[key]
+			if !ok {
+				role = &Role{}
+				if err := tx.Scopes(notDeletedRole).
+					Where("roleable_type = ?", key.roleableType).
+					Where("roleable_id = ?", key.roleableID).
+					Where("name = ?", key.name).
+					Limit(1).Find(role).Error; err != nil {
+					return err
+				}
+				if role.ID == 0 {
+					return fmt.Errorf("%w: role name %s not found in %s:%d", ErrRoleNotFound, key.name, key.roleableType, key.roleableID)
+				}
+				rolesMap[key] = role
+			}
+			result.UserRoles = append(result.UserRoles, &UserRole{
+				UserID: userIDs[i],
+				RoleID: role.ID,
+			})
+		}
+		for _, guard := range guards {
+			if err := guard.check(tx); err != nil {
+				return err
+			}
+		}
+		if len(result.UserRoles) == 0 {
+			return nil
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "role_id"}},
+			DoNothing: true,
+		}).Create(result.UserRoles).Error; err != nil {
+			return err
+		}
+
+		for _, before := range userRolesBefore {
+			if err := s.bumpUserRolesVersions(tx, before.RoleableType, before.RoleableID, before.UserID); err != nil {
+				return err
+			}
+			if err := s.checkRoleConstraints(tx, before.UserID, before.RoleableID, before.RoleableType); err != nil {
+				return err
+			}
+			if err := s.emitUserRolesChanged(tx, emit, before); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,246 @@
+package permission
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CloneRoleParam struct {
+	RoleID                  int64    `json:"role_id" yaml:"role_id"`
+	Name                    string   `json:"name" yaml:"name"`
+	Title                   string   `json:"title" yaml:"title"`                 // 为空时使用原角色标题
+	Description             string   `json:"description" yaml:"description"`     // 为空时使用原角色描述
+	RoleableType            string   `json:"roleable_type" yaml:"roleable_type"` // 为空时复制到原角色所属对象
+	RoleableID              int64    `json:"roleable_id" yaml:"roleable_id"`
+	ExcludePermissionGroups []string `json:"exclude_permission_groups" yaml:"exclude_permission_groups"` // 不复制的权限组
+	CreatorUserID           int64    `json:"creator_user_id" yaml:"creator_user_id"`
+}
+
+// 以新的 name 复制角色及其权限组，可复制到其他对象下，不复制用户角色
+func (s *PermissionService) CloneRole(ctx context.Context, param CloneRoleParam) (_ *Role, err error) {
+	defer s.observe(ctx, "CloneRole", time.Now(), &err)
+	return s.cloneRole(ctx, param, nil)
+}
+
+// check 不为 nil 时在复制角色的事务中执行，参数为新角色和将要复制的权限组，返回错误时不复制
+func (s *PermissionService) cloneRole(ctx context.Context, param CloneRoleParam, check func(tx *gorm.DB, role *Role, permissionGroupNames []string) error) (*Role, error) {
+	source, err := s.GetRole(ctx, param.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	role := &Role{
+		RoleableType:  source.RoleableType,
+		RoleableID:    source.RoleableID,
+		Name:          param.Name,
+		Title:         source.Title,
+		Description:   source.Description,
+		CreatorUserID: param.CreatorUserID,
+	}
+	if param.RoleableType != "" {
+		role.RoleableType = param.RoleableType
+		role.RoleableID = param.RoleableID
+	}
+	if param.Title != "" {
+		role.Title = param.Title
+	}
+	if param.Description != "" {
+		role.Description = param.Description
+	}
+
+	excludePermissionGroupsMap := make(map[string]struct{}, len(param.ExcludePermissionGroups))
+	for _, name := range param.ExcludePermissionGroups {
+		excludePermissionGroupsMap[name] = struct{}{}
+	}
+
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		var count int64
+		if err := tx.Model(&Role{}).
+			Scopes(notDeletedRole).
+			Where("roleable_type = ?", role.RoleableType).
+			Where("roleable_id = ?", role.RoleableID).
+			Where("name = ?", role.Name).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: role name %s existed in %s:%d", ErrRoleAlreadyExists, role.Name, role.RoleableType, role.RoleableID)
+		}
+
+		var permissionGroupNames []string
+		if err := tx.Model(&RolePermissionGroup{}).
+			Where("role_id = ?", source.ID).
+			Order("permission_group_name").
+			Pluck("permission_group_name", &permissionGroupNames).Error; err != nil {
+			return err
+		}
+		clonedPermissionGroupNames := make([]string, 0, len(permissionGroupNames))
+		for _, name := range permissionGroupNames {
+			if _, ok := excludePermissionGroupsMap[name]; !ok {
+				clonedPermissionGroupNames = append(clonedPermissionGroupNames, name)
+			}
+		}
+		if check != nil {
+			if err := check(tx, role, clonedPermissionGroupNames); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(role).Error; err != nil {
+			return err
+		}
+		if err := s.assignPermissionGroupsToRole(tx, role.ID, clonedPermissionGroupNames); err != nil {
+			return err
+		}
+		return s.emitRoleSaved(tx, emit, nil, role.ID)
+	}); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+type CloneRoleableRolesParam struct {
+	SourceRoleableType string `json:"source_roleable_type" yaml:"source_roleable_type"`
+	SourceRoleableID   int64  `json:"source_roleable_id" yaml:"source_roleable_id"`
+	TargetRoleableType string `json:"target_roleable_type" yaml:"target_roleable_type"`
+	TargetRoleableID   int64  `json:"target_roleable_id" yaml:"target_roleable_id"`
+	WithUserRoles      bool   `json:"with_user_roles" yaml:"with_user_roles"` // 是否同时复制用户角色
+}
+
+// 在一个事务中将某个对象下的所有角色复制到另一个对象下
+//
+// 目标对象下已存在同名角色时（比如已同步的预置角色）复用该角色，并将其权限组替换为源角色的权限组
+//
+// 不检查操作者的权限组，只用于管理员或后台任务
+func (s *PermissionService) CloneRoleableRoles(ctx context.Context, param CloneRoleableRolesParam) (_ []*Role, err error) {
+	defer s.observe(ctx, "CloneRoleableRoles", time.Now(), &err)
+	if param.SourceRoleableType == param.TargetRoleableType && param.SourceRoleableID == param.TargetRoleableID {
+		return nil, fmt.Errorf("%w: source and target roleable are the same", ErrInvalidArgument)
+	}
+
+	var clonedRoles []*Role
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		var sourceRoles []*Role
+		if err := tx.Scopes(notDeletedRole).
+			Where("roleable_type = ?", param.SourceRoleableType).
+			Where("roleable_id = ?", param.SourceRoleableID).
+			Order("id").Find(&sourceRoles).Error; err != nil {
+			return err
+		}
+		// 目标对象下同名角色的权限组会被替换
+		guard, err := s.newMinHoldersGuard(tx, param.TargetRoleableType, param.TargetRoleableID)
+		if err != nil {
+			return err
+		}
+
+		// 记录会被复制角色的用户在目标对象下原有的角色
+		var userRolesBefore []*UserRolesSnapshot
+		if param.WithUserRoles && len(sourceRoles) > 0 {
+			sourceRoleIDs := make([]int64, 0, len(sourceRoles))
+			for _, source := range sourceRoles {
+				sourceRoleIDs = append(sourceRoleIDs, source.ID)
+			}
+			var userIDs []int64
+			if err := tx.Model(&UserRole{}).Distinct("user_id").Where("role_id IN ?", sourceRoleIDs).Order("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+				return err
+			}
+			for _, userID := range userIDs {
+				snapshot, err := s.userRolesSnapshot(tx, userID, param.TargetRoleableID, param.TargetRoleableType)
+				if err != nil {
+					return err
+				}
+				userRolesBefore = append(userRolesBefore, snapshot)
+			}
+		}
+
+		for _, source := range sourceRoles {
+			var before *RoleSnapshot
+			if s.hasHooks() {
+				var err error
+				if before, err = s.roleSnapshotByName(tx, param.TargetRoleableType, param.TargetRoleableID, source.Name); err != nil {
+					return err
+				}
+			}
+			role := &Role{
+				RoleableType:  param.TargetRoleableType,
+				RoleableID:    param.TargetRoleableID,
+				Name:          source.Name,
+				Title:         source.Title,
+				Description:   source.Description,
+				CreatorUserID: source.CreatorUserID,
+			}
+			if err := tx.Scopes(notDeletedRole).FirstOrCreate(role, &Role{
+				RoleableType: param.TargetRoleableType,
+				RoleableID:   param.TargetRoleableID,
+				Name:         source.Name,
+			}).Error; err != nil {
+				return err
+			}
+
+			var permissionGroupNames []string
+			if err := tx.Model(&RolePermissionGroup{}).
+				Where("role_id = ?", source.ID).
+				Order("permission_group_name").
+				Pluck("permission_group_name", &permissionGroupNames).Error; err != nil {
+				return err
+			}
+			// 源角色没有权限组时（比如通过导入创建或权限组已被删除）复制为没有权限组的角色
+			if len(permissionGroupNames) == 0 {
+				if err := tx.Where("role_id = ?", role.ID).Delete(&RolePermissionGroup{}).Error; err != nil {
+					return err
+				}
+			} else if err := s.assignPermissionGroupsToRole(tx, role.ID, permissionGroupNames); err != nil {
+				return err
+			}
+			if err := s.emitRoleSaved(tx, emit, before, role.ID); err != nil {
+				return err
+			}
+
+			if param.WithUserRoles {
+				var userIDs []int64
+				if err := tx.Model(&UserRole{}).Where("role_id = ?", source.ID).Order("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+					return err
+				}
+				if len(userIDs) > 0 {
+					userRoles := make([]*UserRole, 0, len(userIDs))
+					for _, userID := range userIDs {
+						userRoles = append(userRoles, &UserRole{
+							UserID: userID,
+							RoleID: role.ID,
+						})
+					}
+					if err := tx.Clauses(clause.OnConflict{
+						Columns:   []clause.Column{{Name: "user_id"}, {Name: "role_id"}},
+						DoNothing: true,
+					}).Create(userRoles).Error; err != nil {
+						return err
+					}
+				}
+			}
+			clonedRoles = append(clonedRoles, role)
+		}
+		if err := guard.check(tx); err != nil {
+			return err
+		}
+
+		for _, before := range userRolesBefore {
+			if err := s.bumpUserRolesVersions(tx, before.RoleableType, before.RoleableID, before.UserID); err != nil {
+				return err
+			}
+			if err := s.checkRoleConstraints(tx, before.UserID, before.RoleableID, before.RoleableType); err != nil {
+				return err
+			}
+			if err := s.emitUserRolesChanged(tx, emit, before); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return clonedRoles, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMetadataPath = "../../examples/metadata.yaml"
+
+func TestOpenDB(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		wantErr bool
+	}{
+		{name: "sqlite", dsn: "sqlite://" + filepath.Join(t.TempDir(), "permctl.db")},
+		{name: "sqlite memory", dsn: "sqlite://:memory:"},
+		{name: "unsupported scheme", dsn: "sqlserver://localhost", wantErr: true},
+		{name: "without scheme", dsn: "permctl.db", wantErr: true},
+		{name: "empty", dsn: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := openDB(tt.dsn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openDB() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if err := db.Exec("SELECT 1").Error; err != nil {
+					t.Errorf("openDB() db.Exec() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	dsn := "sqlite://" + filepath.Join(dir, "permctl.db")
+	invalidMetadataPath := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalidMetadataPath, []byte("permission_groups:\n  - name: app-manage\n    permissions:\n      - not-existed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 按顺序执行，后面的命令依赖前面 apply 和 grant 写入的数据
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+	}{
+		{name: "no command", args: nil, wantCode: 2},
+		{name: "unknown command", args: []string{"unknown"}, wantCode: 2},
+		{name: "help", args: []string{"help"}, wantCode: 2},
+		{name: "command help", args: []string{"validate", "-h"}, wantCode: 2},
+		{name: "validate", args: []string{"validate", "-metadata", testMetadataPath}, wantCode: 0, wantStdout: "ok: 10 permissions"},
+		{name: "validate invalid", args: []string{"validate", "-metadata", invalidMetadataPath}, wantCode: 1},
+		{name: "validate without metadata", args: []string{"validate"}, wantCode: 1},
+		{name: "plan unsupported dsn", args: []string{"plan", "-dsn", "permctl.db", "-metadata", testMetadataPath}, wantCode: 1},
+		{name: "apply invalid metadata", args: []string{"apply", "-dsn", dsn, "-metadata", invalidMetadataPath, "-migrate"}, wantCode: 1},
+		{name: "apply roleable type without id", args: []string{"apply", "-dsn", dsn, "-metadata", testMetadataPath, "-migrate", "-roleable-type", "app"}, wantCode: 1},
+		{name: "plan without tables after rejected apply", args: []string{"plan", "-dsn", dsn, "-metadata", testMetadataPath}, wantCode: 1},
+		{name: "apply", args: []string{"apply", "-dsn", dsn, "-metadata", testMetadataPath, "-migrate", "-roleable-type", "app", "-roleable-id", "1"}, wantCode: 0, wantStdout: "+ permission apps-list-get"},
+		{name: "plan after apply", args: []string{"plan", "-dsn", dsn, "-metadata", testMetadataPath}, wantCode: 0, wantStdout: "no changes"},
+		{name: "check denied", args: []string{"check", "-dsn", dsn, "-roleable-type", "app", "-roleable-id", "1", "-user-id", "1", "-resource", "/api/v1/apps", "-action", "GET"}, wantCode: 1, wantStdout: "denied"},
+		{name: "check without resource", args: []string{"check", "-dsn", dsn, "-roleable-type", "app", "-roleable-id", "1", "-user-id", "1", "-action", "GET"}, wantCode: 1},
+		{name: "grant", args: []string{"grant", "-dsn", dsn, "-metadata", testMetadataPath, "-roleable-type", "app", "-roleable-id", "1", "-user-id", "1", "-roles", "admin"}, wantCode: 0},
+		{name: "check allowed", args: []string{"check", "-dsn", dsn, "-roleable-type", "app", "-roleable-id", "1", "-user-id", "1", "-resource", "/api/v1/apps", "-action", "GET"}, wantCode: 0, wantStdout: "allowed"},
+		{name: "violations", args: []string{"violations", "-dsn", dsn, "-metadata", testMetadataPath, "-roleable-type", "app"}, wantCode: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			stdout, stderr = &out, &errOut
+			defer func() {
+				stdout, stderr = os.Stdout, os.Stderr
+			}()
+
+			if code := run(context.Background(), tt.args); code != tt.wantCode {
+				t.Errorf("run() = %d, want %d, stderr = %s", code, tt.wantCode, errOut.String())
+			}
+			if !strings.Contains(out.String(), tt.wantStdout) {
+				t.Errorf("run() stdout = %q, want contains %q", out.String(), tt.wantStdout)
+			}
+		})
+	}
+}
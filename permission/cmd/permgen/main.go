@@ -0,0 +1,292 @@
+// permgen 根据权限元数据生成权限、权限组、预置角色 name 的 Go 常量，以及使用常量检查权限的 HasPermission 函数
+//
+// 用法:
+//
+//	//go:generate go run git.sofunny.io/data-analysis/gotools/permission/cmd/permgen -metadata metadata.yaml
+//
+// 通过 go generate 执行时默认使用当前包名，默认输出到 permission_gen.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"git.sofunny.io/data-analysis/gotools/permission"
+)
+
+func main() {
+	metadataPath := flag.String("metadata", "", "权限元数据文件，支持 .yaml | .yml | .json")
+	packageName := flag.String("package", os.Getenv("GOPACKAGE"), "生成文件的包名，默认读取 go generate 设置的环境变量 GOPACKAGE")
+	output := flag.String("output", "permission_gen.go", "生成文件路径")
+	flag.Parse()
+
+	if err := run(*metadataPath, *packageName, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "permgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(metadataPath, packageName, output string) error {
+	if metadataPath == "" {
+		return errors.New("flag -metadata is required")
+	}
+	if packageName == "" {
+		return errors.New("flag -package is required when not running by go generate")
+	}
+
+	f, err := os.Open(metadataPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	format := permission.MetadataFormatYAML
+	if strings.EqualFold(filepath.Ext(metadataPath), ".json") {
+		format = permission.MetadataFormatJSON
+	}
+	metadata, err := permission.DecodePermissionMetadata(f, format)
+	if err != nil {
+		return err
+	}
+	if err := metadata.Validate(); err != nil {
+		return err
+	}
+
+	content, err := generate(metadata, packageName)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, content, 0o644)
+}
+
+type constItem struct {
+	Ident string
+	Value string
+	Title string
+}
+
+type permissionItem struct {
+	constItem
+	Domain   string
+	Resource string
+	Action   string
+}
+
+type templateData struct {
+	Package          string
+	RoleableTypes    []*constItem
+	Permissions      []*permissionItem
+	PermissionGroups []*constItem
+	Roles            []*constItem
+}
+
+var codeTemplate = template.Must(template.New("code").Parse(`// Code generated by permgen. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	"context"
+
+	"git.sofunny.io/data-analysis/gotools/permission"
+)
+
+// 角色类型
+type RoleableType string
+
+const (
+{{- range .RoleableTypes }}
+	{{ .Ident }} RoleableType = {{ printf "%q" .Value }}
+{{- end }}
+)
+
+// 权限 name
+type PermissionName string
+
+const (
+{{- range .Permissions }}
+	PermissionName{{ .Ident }} PermissionName = {{ printf "%q" .Value }} // {{ .Title }}
+{{- end }}
+)
+
+// 权限组 name
+type PermissionGroupName string
+
+const (
+{{- range .PermissionGroups }}
+	PermissionGroupName{{ .Ident }} PermissionGroupName = {{ printf "%q" .Value }} // {{ .Title }}
+{{- end }}
+)
+
+// 预置角色 name
+type RoleName string
+
+const (
+{{- range .Roles }}
+	RoleName{{ .Ident }} RoleName = {{ printf "%q" .Value }} // {{ .Title }}
+{{- end }}
+)
+
+// 权限及其 domain, resource, action，字段不可导出，只能使用下面生成的变量
+type Permission struct {
+	name     PermissionName
+	domain   string
+	resource string
+	action   string
+}
+
+func (p Permission) Name() PermissionName { return p.name }
+func (p Permission) Domain() string       { return p.domain }
+func (p Permission) Resource() string     { return p.resource }
+func (p Permission) Action() string       { return p.action }
+
+var (
+{{- range .Permissions }}
+	Permission{{ .Ident }} = Permission{name: PermissionName{{ .Ident }}, domain: {{ printf "%q" .Domain }}, resource: {{ printf "%q" .Resource }}, action: {{ printf "%q" .Action }}} // {{ .Title }}
+{{- end }}
+)
+
+// 检查用户在对象下是否拥有权限，p 只能使用生成的 Permission 变量，拼写错误在编译时发现
+func HasPermission(ctx context.Context, svc *permission.PermissionService, userID int64, roleableType RoleableType, roleableID int64, p Permission) (bool, error) {
+	return svc.HasPermission(ctx, permission.HasPermissionParam{
+		UserID:       userID,
+		RoleableType: string(roleableType),
+		RoleableID:   roleableID,
+		Domain:       p.domain,
+		Resource:     p.resource,
+		Action:       p.action,
+	})
+}
+`))
+
+// 生成 Go 代码
+func generate(metadata *permission.PermissionMetadata, packageName string) ([]byte, error) {
+	data := templateData{Package: packageName}
+	var errs []error
+
+	// 所有生成的标识符在同一个包内，使用同一个集合检查冲突
+	idents := newIdentSet()
+	for _, p := range metadata.Permissions {
+		ident, err := idents.add("permission", p.Name, "PermissionName", "Permission")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		data.Permissions = append(data.Permissions, &permissionItem{
+			constItem: constItem{Ident: ident, Value: p.Name, Title: commentText(p.Title)},
+			Domain:    p.Domain,
+			Resource:  p.Resource,
+			Action:    p.Action,
+		})
+	}
+
+	var addPermissionGroups func(groups []*permission.PermissionGroupItem)
+	addPermissionGroups = func(groups []*permission.PermissionGroupItem) {
+		for _, g := range groups {
+			ident, err := idents.add("permission group", g.Name, "PermissionGroupName")
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				data.PermissionGroups = append(data.PermissionGroups, &constItem{Ident: ident, Value: g.Name, Title: commentText(g.Title)})
+			}
+			addPermissionGroups(g.PermissionGroups)
+		}
+	}
+	addPermissionGroups(metadata.PermissionGroups)
+
+	roleableTypesMap := make(map[string]struct{})
+	for _, r := range metadata.Roles {
+		if _, ok := roleableTypesMap[r.RoleableType]; !ok {
+			roleableTypesMap[r.RoleableType] = struct{}{}
+			ident, err := idents.add("roleable type", r.RoleableType, "RoleableType")
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				data.RoleableTypes = append(data.RoleableTypes, &constItem{Ident: "RoleableType" + ident, Value: r.RoleableType})
+			}
+		}
+		// 角色 name 只在同一角色类型下唯一，常量名加上角色类型前缀
+		ident, err := idents.add("role", r.RoleableType+"-"+r.Name, "RoleName")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		data.Roles = append(data.Roles, &constItem{Ident: ident, Value: r.Name, Title: commentText(r.Title)})
+	}
+	sort.Slice(data.RoleableTypes, func(i, j int) bool {
+		return data.RoleableTypes[i].Value < data.RoleableTypes[j].Value
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := codeTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// 模板中固定生成的标识符
+var templateIdents = []string{"RoleableType", "PermissionName", "PermissionGroupName", "RoleName", "Permission", "HasPermission"}
+
+// 记录已生成的标识符及其来源，避免不同 name 转换后冲突
+type identSet struct {
+	idents map[string]string
+}
+
+func newIdentSet() *identSet {
+	s := &identSet{idents: make(map[string]string, len(templateIdents))}
+	for _, ident := range templateIdents {
+		s.idents[ident] = "generated type or func " + ident
+	}
+	return s
+}
+
+// 按 prefixes 生成并记录标识符，返回不带前缀的部分
+func (s *identSet) add(kind, name string, prefixes ...string) (string, error) {
+	ident := toIdent(name)
+	if ident == "" {
+		return "", fmt.Errorf("%s name:%q can not be converted to identifier", kind, name)
+	}
+	source := fmt.Sprintf("%s name:%s", kind, name)
+	for _, prefix := range prefixes {
+		if existed, ok := s.idents[prefix+ident]; ok {
+			return "", fmt.Errorf("%s and %s both converted to identifier %s", existed, source, prefix+ident)
+		}
+	}
+	for _, prefix := range prefixes {
+		s.idents[prefix+ident] = source
+	}
+	return ident, nil
+}
+
+// 将 name 转换为驼峰标识符，比如 app-posts-delete 转换为 AppPostsDelete
+func toIdent(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// 注释中不能包含换行
+func commentText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"git.sofunny.io/data-analysis/gotools/permission"
+)
+
+func TestGenerate(t *testing.T) {
+	metadata := &permission.PermissionMetadata{
+		Permissions: []*permission.PermissionItem{
+			{Name: "app-posts-delete", Title: "删除应用文章", Resource: "/api/v1/apps/:id/posts/:postID", Action: "DELETE"},
+		},
+		PermissionGroups: []*permission.PermissionGroupItem{
+			{Name: "app-post-manage", Title: "应用文章管理", Permissions: []string{"app-posts-delete"}},
+		},
+		Roles: []*permission.RolePermissionGroupItem{
+			{RoleableType: "app", Name: "admin", Title: "管理员", PermissionGroups: []string{"app-post-manage"}},
+		},
+	}
+	content, err := generate(metadata, "perms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, content)
+	for _, want := range []string{
+		`RoleableTypeApp RoleableType = "app"`,
+		`PermissionNameAppPostsDelete PermissionName = "app-posts-delete"`,
+		`PermissionGroupNameAppPostManage PermissionGroupName = "app-post-manage"`,
+		`RoleNameAppAdmin RoleName = "admin"`,
+		`PermissionAppPostsDelete = Permission{name: PermissionNameAppPostsDelete, domain: "", resource: "/api/v1/apps/:id/posts/:postID", action: "DELETE"}`,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("generated code missing %s", want)
+		}
+	}
+
+	metadata.Permissions = append(metadata.Permissions, &permission.PermissionItem{Name: "app_posts_delete", Resource: "/", Action: "DELETE"})
+	if _, err := generate(metadata, "perms"); err == nil {
+		t.Errorf("generate() identifier conflict error = nil")
+	}
+	metadata.Permissions = metadata.Permissions[:1]
+
+	metadata.Roles = append(metadata.Roles,
+		&permission.RolePermissionGroupItem{RoleableType: "app-x", Name: "admin", PermissionGroups: []string{"app-post-manage"}},
+		&permission.RolePermissionGroupItem{RoleableType: "app_x", Name: "editor", PermissionGroups: []string{"app-post-manage"}},
+	)
+	_, err = generate(metadata, "perms")
+	if err == nil || !strings.Contains(err.Error(), "roleable type name:app-x and roleable type name:app_x both converted to identifier RoleableTypeAppX") {
+		t.Errorf("generate() roleable type identifier conflict error = %v", err)
+	}
+	metadata.Roles = metadata.Roles[:1]
+
+	// 不同类别的 name 转换后与其他类别或模板中固定的标识符冲突
+	tests := []struct {
+		name             string
+		permissions      []string
+		permissionGroups []string
+		wantErr          string
+	}{
+		{name: "permission const and var", permissions: []string{"x", "name-x"}, wantErr: "permission name:x and permission name:name-x both converted to identifier PermissionNameX"},
+		{name: "permission var and permission group const", permissions: []string{"group-name-x"}, permissionGroups: []string{"x"}, wantErr: "permission name:group-name-x and permission group name:x both converted to identifier PermissionGroupNameX"},
+		{name: "permission var and type", permissions: []string{"name"}, wantErr: "generated type or func PermissionName and permission name:name both converted to identifier PermissionName"},
+		{name: "no conflict", permissions: []string{"has-permission", "name-group"}, permissionGroups: []string{"name"}, wantErr: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &permission.PermissionMetadata{}
+			for _, name := range tt.permissions {
+				m.Permissions = append(m.Permissions, &permission.PermissionItem{Name: name, Resource: "/" + name, Action: "GET"})
+			}
+			for _, name := range tt.permissionGroups {
+				m.PermissionGroups = append(m.PermissionGroups, &permission.PermissionGroupItem{Name: name})
+			}
+			content, err := generate(m, "perms")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("generate() error = %v", err)
+				}
+				typeCheck(t, content)
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("generate() error = %v, want contains %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 使用 go/types 检查生成的代码可以编译
+func typeCheck(t *testing.T, content []byte) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "permission_gen.go", content, 0)
+	if err != nil {
+		t.Fatalf("generated code parse error: %v", err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("perms", fset, []*ast.File{f}, nil); err != nil {
+		t.Fatalf("generated code type check error: %v\n%s", err, content)
+	}
+}
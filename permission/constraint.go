@@ -0,0 +1,188 @@
+package permission
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// 角色职责分离约束，按角色名称匹配
+type RoleConstraintItem struct {
+	Name           string   `json:"name" yaml:"name"` // 约束名称，用于错误和违规报告
+	RoleableType   string   `json:"roleable_type" yaml:"roleable_type"`
+	ExclusiveRoles []string `json:"exclusive_roles,omitempty" yaml:"exclusive_roles,omitempty"` // 互斥角色，用户在同一对象下最多拥有其中一个
+	MaxRoles       int      `json:"max_roles,omitempty" yaml:"max_roles,omitempty"`             // 用户在同一对象下最多拥有的角色数，为 0 时不限制
+}
+
+// 检查用户在对象下的角色名称，返回违反约束的角色名称，没有违反时返回 nil
+func (c *RoleConstraintItem) violatedRoleNames(roleNames []string) []string {
+	if c.MaxRoles > 0 && len(roleNames) > c.MaxRoles {
+		return roleNames
+	}
+	if len(c.ExclusiveRoles) == 0 {
+		return nil
+	}
+	var exclusiveRoleNames []string
+	for _, name := range roleNames {
+		for _, exclusiveRole := range c.ExclusiveRoles {
+			if name == exclusiveRole {
+				exclusiveRoleNames = append(exclusiveRoleNames, name)
+				break
+			}
+		}
+	}
+	if len(exclusiveRoleNames) > 1 {
+		return exclusiveRoleNames
+	}
+	return nil
+}
+
+func (c *RoleConstraintItem) String() string {
+	if c.MaxRoles > 0 && len(c.ExclusiveRoles) == 0 {
+		return fmt.Sprintf("%s: at most %d roles per user", c.Name, c.MaxRoles)
+	}
+	if c.MaxRoles > 0 {
+		return fmt.Sprintf("%s: roles %v are mutually exclusive and at most %d roles per user", c.Name, c.ExclusiveRoles, c.MaxRoles)
+	}
+	return fmt.Sprintf("%s: roles %v are mutually exclusive", c.Name, c.ExclusiveRoles)
+}
+
+// 校验职责分离约束
+func (c *RoleConstraintItem) validate() []error {
+	var errs []error
+	if c.Name == "" {
+		errs = append(errs, fmt.Errorf("role constraint roleable_type:%s name empty", c.RoleableType))
+	}
+	if len(c.ExclusiveRoles) == 1 {
+		errs = append(errs, fmt.Errorf("role constraint name:%s exclusive_roles need at least 2 roles", c.Name))
+	}
+	if len(c.ExclusiveRoles) == 0 && c.MaxRoles <= 0 {
+		errs = append(errs, fmt.Errorf("role constraint name:%s needs exclusive_roles or max_roles", c.Name))
+	}
+	return errs
+}
+
+// 职责分离约束的违规记录
+type RoleConstraintViolation struct {
+	Constraint   string   `json:"constraint" yaml:"constraint"` // 约束名称
+	UserID       int64    `json:"user_id" yaml:"user_id"`
+	RoleableType string   `json:"roleable_type" yaml:"roleable_type"`
+	RoleableID   int64    `json:"roleable_id" yaml:"roleable_id"`
+	RoleNames    []string `json:"role_names" yaml:"role_names"` // 违反约束的角色
+}
+
+// 检查用户在对象下的角色是否满足职责分离约束
+func (s *PermissionService) checkRoleConstraints(tx *gorm.DB, userID, roleableID int64, roleableType string) error {
+	constraints := s.roleConstraints(roleableType)
+	if len(constraints) == 0 {
+		return nil
+	}
+
+	var roleNames []string
+	if err := tx.Model(&Role{}).
+		Scopes(notDeletedRole).
+		Where("roleable_type = ?", roleableType).
+		Where("roleable_id = ?", roleableID).
+		Where("id IN (?)", tx.Model(&UserRole{}).Select("role_id").Where("user_id = ?", userID)).
+		Order("name").Pluck("name", &roleNames).Error; err != nil {
+		return err
+	}
+	for _, c := range constraints {
+		if violated := c.violatedRoleNames(roleNames); violated != nil {
+			return &RoleConstraintError{
+				Constraint: *c,
+				UserID:     userID,
+				RoleableID: roleableID,
+				RoleNames:  violated,
+			}
+		}
+	}
+	return nil
+}
+
+// 检查拥有某个角色的所有用户是否满足职责分离约束
+func (s *PermissionService) checkRoleConstraintsByRole(tx *gorm.DB, role *Role) error {
+	if len(s.roleConstraints(role.RoleableType)) == 0 {
+		return nil
+	}
+	var userIDs []int64
+	if err := tx.Model(&UserRole{}).Where("role_id = ?", role.ID).Order("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if err := s.checkRoleConstraints(tx, userID, role.RoleableID, role.RoleableType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PermissionService) roleConstraints(roleableType string) []*RoleConstraintItem {
+	var constraints []*RoleConstraintItem
+	for _, c := range s.metadata.RoleConstraints {
+		if c.RoleableType == roleableType {
+			constraints = append(constraints, c)
+		}
+	}
+	return constraints
+}
+
+// 获取某个对象类型下已存在的职责分离约束违规，比如新增约束前已分配的用户角色
+func (s *PermissionService) GetRoleConstraintViolations(ctx context.Context, roleableType string) ([]*RoleConstraintViolation, error) {
+	constraints := s.roleConstraints(roleableType)
+	if len(constraints) == 0 {
+		return nil, nil
+	}
+
+	sql := fmt.Sprintf(`SELECT ur.user_id, r.roleable_id, r.name FROM %s ur
+		INNER JOIN %s r ON r.id = ur.role_id
+		WHERE r.roleable_type = ? AND r.deleted_at = 0
+		ORDER BY r.roleable_id, ur.user_id, r.name`,
+		s.cachedTableNames.userRoleTableName,
+		s.cachedTableNames.roleTableName)
+
+	var rows []struct {
+		UserID     int64
+		RoleableID int64
+		Name       string
+	}
+	if err := s.db.WithContext(ctx).Raw(sql, roleableType).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	type userRoleableKey struct {
+		userID     int64
+		roleableID int64
+	}
+	var keys []userRoleableKey
+	roleNamesMap := make(map[userRoleableKey][]string)
+	for _, row := range rows {
+		key := userRoleableKey{userID: row.UserID, roleableID: row.RoleableID}
+		if _, ok := roleNamesMap[key]; !ok {
+			keys = append(keys, key)
+		}
+		roleNamesMap[key] = append(roleNamesMap[key], row.Name)
+	}
+
+	var violations []*RoleConstraintViolation
+	for _, key := range keys {
+		roleNames := roleNamesMap[key]
+		sort.Strings(roleNames)
+		for _, c := range constraints {
+			violated := c.violatedRoleNames(roleNames)
+			if violated == nil {
+				continue
+			}
+			violations = append(violations, &RoleConstraintViolation{
+				Constraint:   c.Name,
+				UserID:       key.userID,
+				RoleableType: roleableType,
+				RoleableID:   key.roleableID,
+				RoleNames:    violated,
+			})
+		}
+	}
+	return violations, nil
+}
@@ -0,0 +1,80 @@
+package permission
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidArgument         = errors.New("invalid argument")
+	ErrPermissionGroupNotFound = errors.New("permission group not found")
+	ErrEmptyPermissionGroups   = errors.New("role must have at least one permission groups")
+	ErrRoleNotFound            = errors.New("role not found")
+	ErrRoleAlreadyExists       = errors.New("role already exists")
+	ErrVersionConflict         = errors.New("version conflict")
+	ErrMinHoldersViolated      = errors.New("min holders violated")
+	ErrRoleConstraintViolated  = errors.New("role constraint violated")
+	ErrPrivilegeEscalation     = errors.New("privilege escalation")
+)
+
+// 乐观锁版本冲突，数据在读取后已被修改，可通过 errors.Is(err, ErrVersionConflict) 判断
+type VersionConflictError struct {
+	Resource        string // 冲突的数据，比如 role:1, user_roles:1@app:1
+	ExpectedVersion string // 调用方读取时的版本
+	ActualVersion   string // 当前版本
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s: %s expected version %s, actual version %s", ErrVersionConflict, e.Resource, e.ExpectedVersion, e.ActualVersion)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// 变更导致对象下的角色持有人数低于约束的最小值，可通过 errors.Is(err, ErrMinHoldersViolated) 判断
+type MinHoldersError struct {
+	Rule       MinHoldersRule
+	RoleableID int64
+	Holders    int64 // 变更后的持有人数
+}
+
+func (e *MinHoldersError) Error() string {
+	return fmt.Sprintf("%s: %s, %s:%d would have %d", ErrMinHoldersViolated, e.Rule.String(), e.Rule.RoleableType, e.RoleableID, e.Holders)
+}
+
+func (e *MinHoldersError) Is(target error) bool {
+	return target == ErrMinHoldersViolated
+}
+
+// 用户角色违反职责分离约束，可通过 errors.Is(err, ErrRoleConstraintViolated) 判断
+type RoleConstraintError struct {
+	Constraint RoleConstraintItem
+	UserID     int64
+	RoleableID int64
+	RoleNames  []string // 违反约束的角色
+}
+
+func (e *RoleConstraintError) Error() string {
+	return fmt.Sprintf("%s: %s, user %d has roles %v in %s:%d", ErrRoleConstraintViolated, e.Constraint.String(), e.UserID, e.RoleNames, e.Constraint.RoleableType, e.RoleableID)
+}
+
+func (e *RoleConstraintError) Is(target error) bool {
+	return target == ErrRoleConstraintViolated
+}
+
+// 操作者授予了自己在对象下没有的权限组，可通过 errors.Is(err, ErrPrivilegeEscalation) 判断
+type PrivilegeEscalationError struct {
+	ActorUserID      int64
+	RoleableType     string
+	RoleableID       int64
+	PermissionGroups []string // 操作者没有的权限组
+}
+
+func (e *PrivilegeEscalationError) Error() string {
+	return fmt.Sprintf("%s: user %d does not hold permission groups %v in %s:%d", ErrPrivilegeEscalation, e.ActorUserID, e.PermissionGroups, e.RoleableType, e.RoleableID)
+}
+
+func (e *PrivilegeEscalationError) Is(target error) bool {
+	return target == ErrPrivilegeEscalation
+}
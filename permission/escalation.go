@@ -0,0 +1,157 @@
+package permission
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 以 actorUserID 的身份创建角色，角色的权限组需要是 actorUserID 在该对象下拥有的权限组
+func (s *PermissionService) CreateRoleAs(ctx context.Context, actorUserID int64, param CreateRoleParam) (_ *Role, err error) {
+	defer s.observe(ctx, "CreateRoleAs", time.Now(), &err)
+	return s.createRole(ctx, param, func(tx *gorm.DB) error {
+		return s.checkGrantablePermissionGroups(tx, actorUserID, param.RoleableType, param.RoleableID, param.PermissionGroups)
+	})
+}
+
+// 以 actorUserID 的身份更新角色，新增的权限组需要是 actorUserID 在该对象下拥有的权限组，保留或移除权限组不受限制
+func (s *PermissionService) UpdateRoleAs(ctx context.Context, actorUserID int64, param UpdateRoleParam) (_ *Role, err error) {
+	defer s.observe(ctx, "UpdateRoleAs", time.Now(), &err)
+	return s.updateRole(ctx, param, func(tx *gorm.DB, role *Role) error {
+		var permissionGroupNames []string
+		if err := tx.Model(&RolePermissionGroup{}).
+			Where("role_id = ?", role.ID).
+			Pluck("permission_group_name", &permissionGroupNames).Error; err != nil {
+			return err
+		}
+		addedPermissionGroupNames := subtractStrings(param.PermissionGroups, permissionGroupNames)
+		return s.checkGrantablePermissionGroups(tx, actorUserID, role.RoleableType, role.RoleableID, addedPermissionGroupNames)
+	})
+}
+
+// 以 actorUserID 的身份复制角色，复制的权限组需要是 actorUserID 在目标对象下拥有的权限组
+func (s *PermissionService) CloneRoleAs(ctx context.Context, actorUserID int64, param CloneRoleParam) (_ *Role, err error) {
+	defer s.observe(ctx, "CloneRoleAs", time.Now(), &err)
+	return s.cloneRole(ctx, param, func(tx *gorm.DB, role *Role, permissionGroupNames []string) error {
+		return s.checkGrantablePermissionGroups(tx, actorUserID, role.RoleableType, role.RoleableID, permissionGroupNames)
+	})
+}
+
+// 以 actorUserID 的身份删除角色，角色的权限组需要都是 actorUserID 在该对象下拥有的权限组
+func (s *PermissionService) DeleteRoleAs(ctx context.Context, actorUserID int64, roleID int64) (err error) {
+	defer s.observe(ctx, "DeleteRoleAs", time.Now(), &err)
+	return s.deleteRole(ctx, roleID, func(tx *gorm.DB, role *Role) error {
+		return s.checkGrantableRoles(tx, actorUserID, role.RoleableType, role.RoleableID, []int64{role.ID})
+	})
+}
+
+// 以 actorUserID 的身份恢复已软删除的角色，角色的权限组需要都是 actorUserID 在该对象下拥有的权限组
+func (s *PermissionService) RestoreRoleAs(ctx context.Context, actorUserID int64, roleID int64) (_ *Role, err error) {
+	defer s.observe(ctx, "RestoreRoleAs", time.Now(), &err)
+	return s.restoreRole(ctx, roleID, func(tx *gorm.DB, role *Role) error {
+		return s.checkGrantableRoles(tx, actorUserID, role.RoleableType, role.RoleableID, []int64{role.ID})
+	})
+}
+
+// 以 actorUserID 的身份为用户分配角色，新分配和移除的角色的权限组需要都是 actorUserID 在该对象下拥有的权限组
+func (s *PermissionService) AssignRolesToUserAs(ctx context.Context, actorUserID int64, param AssignRolesToUserParam) (err error) {
+	defer s.observe(ctx, "AssignRolesToUserAs", time.Now(), &err)
+	return s.assignRolesToUser(ctx, param, func(tx *gorm.DB) error {
+		userRoleIDs, err := s.getUserRoleIDs(tx, param.UserID, param.RoleableID, param.RoleableType)
+		if err != nil {
+			return err
+		}
+		userRoleIDsMap := make(map[int64]struct{}, len(userRoleIDs))
+		for _, roleID := range userRoleIDs {
+			userRoleIDsMap[roleID] = struct{}{}
+		}
+		paramRoleIDsMap := make(map[int64]struct{}, len(param.RoleIDs))
+		var changedRoleIDs []int64
+		for _, roleID := range param.RoleIDs {
+			paramRoleIDsMap[roleID] = struct{}{}
+			if _, ok := userRoleIDsMap[roleID]; !ok {
+				changedRoleIDs = append(changedRoleIDs, roleID)
+			}
+		}
+		for _, roleID := range userRoleIDs {
+			if _, ok := paramRoleIDsMap[roleID]; !ok {
+				changedRoleIDs = append(changedRoleIDs, roleID)
+			}
+		}
+		return s.checkGrantableRoles(tx, actorUserID, param.RoleableType, param.RoleableID, changedRoleIDs)
+	})
+}
+
+// 检查 actorUserID 在对象下是否拥有角色的所有权限组
+func (s *PermissionService) checkGrantableRoles(tx *gorm.DB, actorUserID int64, roleableType string, roleableID int64, roleIDs []int64) error {
+	if len(roleIDs) == 0 {
+		return nil
+	}
+	var permissionGroupNames []string
+	if err := tx.Model(&RolePermissionGroup{}).
+		Distinct("permission_group_name").
+		Where("role_id IN ?", roleIDs).
+		Pluck("permission_group_name", &permissionGroupNames).Error; err != nil {
+		return err
+	}
+	return s.checkGrantablePermissionGroups(tx, actorUserID, roleableType, roleableID, permissionGroupNames)
+}
+
+// 检查 actorUserID 在对象下是否拥有所有权限组，不存在的权限组留给后续的角色操作报错
+//
+// 需要使用变更的事务 tx，避免检查后、写入前操作者的权限被并发修改
+func (s *PermissionService) checkGrantablePermissionGroups(tx *gorm.DB, actorUserID int64, roleableType string, roleableID int64, permissionGroupNames []string) error {
+	if len(permissionGroupNames) == 0 {
+		return nil
+	}
+	if err := tx.Model(&PermissionGroup{}).
+		Where("name IN ?", permissionGroupNames).
+		Pluck("name", &permissionGroupNames).Error; err != nil {
+		return err
+	}
+	if len(permissionGroupNames) == 0 {
+		return nil
+	}
+	holdPermissionGroupsMap, err := s.hasPermissionGroups(tx, HasPermissionGroupsParam{
+		UserID:               actorUserID,
+		RoleableType:         roleableType,
+		RoleableID:           roleableID,
+		PermissionGroupNames: permissionGroupNames,
+	})
+	if err != nil {
+		return err
+	}
+	var notHeldPermissionGroupNames []string
+	for name, ok := range holdPermissionGroupsMap {
+		if !ok {
+			notHeldPermissionGroupNames = append(notHeldPermissionGroupNames, name)
+		}
+	}
+	if len(notHeldPermissionGroupNames) == 0 {
+		return nil
+	}
+	sort.Strings(notHeldPermissionGroupNames)
+	return &PrivilegeEscalationError{
+		ActorUserID:      actorUserID,
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		PermissionGroups: notHeldPermissionGroupNames,
+	}
+}
+
+// 返回 a 中不在 b 中的元素
+func subtractStrings(a, b []string) []string {
+	bMap := make(map[string]struct{}, len(b))
+	for _, s := range b {
+		bMap[s] = struct{}{}
+	}
+	var result []string
+	for _, s := range a {
+		if _, ok := bMap[s]; !ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package permission
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// 权限检查未通过时缺失的环节
+const (
+	MissingLinkPermission      = "permission"       // 权限不存在
+	MissingLinkPermissionGroup = "permission_group" // 权限不属于任何权限组
+	MissingLinkRole            = "role"             // 对象下没有角色拥有包含该权限的权限组
+	MissingLinkUserRole        = "user_role"        // 用户没有拥有该权限的角色
+)
+
+// 对象下拥有包含该权限的权限组的角色
+type ExplainRole struct {
+	Role             *Role    `json:"role" yaml:"role"`
+	PermissionGroups []string `json:"permission_groups" yaml:"permission_groups"` // 角色拥有的包含该权限的权限组
+	Assigned         bool     `json:"assigned" yaml:"assigned"`                   // 用户是否拥有该角色
+}
+
+// 权限检查的判定过程
+type PermissionExplanation struct {
+	Allowed          bool           `json:"allowed" yaml:"allowed"`
+	Permission       *Permission    `json:"permission" yaml:"permission"`               // 匹配的权限，不存在时为 nil
+	PermissionGroups []string       `json:"permission_groups" yaml:"permission_groups"` // 包含该权限的权限组
+	Roles            []*ExplainRole `json:"roles" yaml:"roles"`                         // 对象下拥有这些权限组的角色
+	UserRoles        []*Role        `json:"user_roles" yaml:"user_roles"`               // 用户在对象下的所有角色
+	MissingLink      string         `json:"missing_link,omitempty" yaml:"missing_link,omitempty"`
+	Reason           string         `json:"reason" yaml:"reason"`
+}
+
+// 输出用户权限检查的判定过程，检查结果和 HasPermission 一致
+func (s *PermissionService) Explain(ctx context.Context, param HasPermissionParam) (_ *PermissionExplanation, err error) {
+	var explanation PermissionExplanation
+	defer func(start time.Time) {
+		s.observeCheck(ctx, "Explain", start, &explanation.Allowed, &err)
+	}(time.Now())
+	db := s.db.WithContext(ctx)
+
+	userRoles, err := s.GetUserRoles(ctx, param.UserID, param.RoleableID, param.RoleableType)
+	if err != nil {
+		return nil, err
+	}
+	explanation.UserRoles = userRoles
+
+	var permission Permission
+	if err := db.Where("domain = ?", param.Domain).
+		Where("resource = ?", param.Resource).
+		Where("action = ?", param.Action).
+		Limit(1).Find(&permission).Error; err != nil {
+		return nil, err
+	}
+	if permission.Name == "" {
+		explanation.MissingLink = MissingLinkPermission
+		explanation.Reason = fmt.Sprintf("permission domain:%s + resource:%s + action:%s not found", param.Domain, param.Resource, param.Action)
+		return &explanation, nil
+	}
+	explanation.Permission = &permission
+
+	if err := db.Model(&PermissionGroupPermission{}).
+		Where("permission_name = ?", permission.Name).
+		Order("permission_group_name").
+		Pluck("permission_group_name", &explanation.PermissionGroups).Error; err != nil {
+		return nil, err
+	}
+	if len(explanation.PermissionGroups) == 0 {
+		explanation.MissingLink = MissingLinkPermissionGroup
+		explanation.Reason = fmt.Sprintf("permission %s not linked to any permission group", permission.Name)
+		return &explanation, nil
+	}
+
+	var roles []*Role
+	if err := db.Model(&Role{}).
+		Scopes(notDeletedRole).
+		Where("roleable_type = ?", param.RoleableType).
+		Where("roleable_id = ?", param.RoleableID).
+		Where("id IN (?)", db.Model(&RolePermissionGroup{}).Select("role_id").Where("permission_group_name IN ?", explanation.PermissionGroups)).
+		Order("id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		explanation.MissingLink = MissingLinkRole
+		explanation.Reason = fmt.Sprintf("no role in %s:%d holds permission groups %v", param.RoleableType, param.RoleableID, explanation.PermissionGroups)
+		return &explanation, nil
+	}
+
+	roleIDs := make([]int64, 0, len(roles))
+	for _, r := range roles {
+		roleIDs = append(roleIDs, r.ID)
+	}
+	var rolePermissionGroups []*RolePermissionGroup
+	if err := db.Where("role_id IN ?", roleIDs).
+		Where("permission_group_name IN ?", explanation.PermissionGroups).
+		Order("permission_group_name").Find(&rolePermissionGroups).Error; err != nil {
+		return nil, err
+	}
+	rolePermissionGroupNamesMap := make(map[int64][]string, len(roles))
+	for _, g := range rolePermissionGroups {
+		rolePermissionGroupNamesMap[g.RoleID] = append(rolePermissionGroupNamesMap[g.RoleID], g.PermissionGroupName)
+	}
+	userRoleIDsMap := make(map[int64]struct{}, len(userRoles))
+	for _, r := range userRoles {
+		userRoleIDsMap[r.ID] = struct{}{}
+	}
+	for _, r := range roles {
+		_, assigned := userRoleIDsMap[r.ID]
+		if assigned {
+			explanation.Allowed = true
+		}
+		explanation.Roles = append(explanation.Roles, &ExplainRole{
+			Role:             r,
+			PermissionGroups: rolePermissionGroupNamesMap[r.ID],
+			Assigned:         assigned,
+		})
+	}
+
+	if !explanation.Allowed {
+		roleNames := make([]string, 0, len(roles))
+		for _, r := range roles {
+			roleNames = append(roleNames, r.Name)
+		}
+		explanation.MissingLink = MissingLinkUserRole
+		explanation.Reason = fmt.Sprintf("user %d has none of roles %v in %s:%d", param.UserID, roleNames, param.RoleableType, param.RoleableID)
+		return &explanation, nil
+	}
+	explanation.Reason = fmt.Sprintf("permission %s granted", permission.Name)
+	return &explanation, nil
+}
@@ -0,0 +1,186 @@
+package permission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// 元数据序列化格式
+const (
+	MetadataFormatYAML = "yaml"
+	MetadataFormatJSON = "json"
+)
+
+type ExportPermissionMetadataParam struct {
+	RoleableType string `json:"roleable_type" yaml:"roleable_type"` // 为空时不导出角色
+	RoleableID   int64  `json:"roleable_id" yaml:"roleable_id"`
+}
+
+// 根据数据库当前状态导出权限元数据，导出结果可再次用于 SyncPermissionMetadata
+func (s *PermissionService) ExportPermissionMetadata(ctx context.Context, param ExportPermissionMetadataParam) (*PermissionMetadata, error) {
+	return s.exportPermissionMetadata(s.db.WithContext(ctx), param)
+}
+
+func (s *PermissionService) exportPermissionMetadata(db *gorm.DB, param ExportPermissionMetadataParam) (*PermissionMetadata, error) {
+	var permissions []*Permission
+	if err := db.Model(&Permission{}).Order("name").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+
+	var permissionGroups []*PermissionGroup
+	if err := db.Model(&PermissionGroup{}).Order("group_index").Order("name").Find(&permissionGroups).Error; err != nil {
+		return nil, err
+	}
+
+	var permissionGroupPermissions []*PermissionGroupPermission
+	if err := db.Model(&PermissionGroupPermission{}).Order("permission_name").Find(&permissionGroupPermissions).Error; err != nil {
+		return nil, err
+	}
+
+	permissionTranslationsMap, err := getAllTranslations(db, TranslationKindPermission, "")
+	if err != nil {
+		return nil, err
+	}
+	permissionGroupTranslationsMap, err := getAllTranslations(db, TranslationKindPermissionGroup, "")
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &PermissionMetadata{
+		Permissions: make([]*PermissionItem, 0, len(permissions)),
+	}
+	for _, p := range permissions {
+		titles, descriptions := translationsToMetadata(permissionTranslationsMap[p.Name])
+		metadata.Permissions = append(metadata.Permissions, &PermissionItem{
+			Name:         p.Name,
+			Title:        p.Title,
+			Description:  p.Description,
+			Domain:       p.Domain,
+			Resource:     p.Resource,
+			Action:       p.Action,
+			Titles:       titles,
+			Descriptions: descriptions,
+		})
+	}
+
+	groupPermissionNamesMap := make(map[string][]string, len(permissionGroups))
+	for _, p := range permissionGroupPermissions {
+		groupPermissionNamesMap[p.PermissionGroupName] = append(groupPermissionNamesMap[p.PermissionGroupName], p.PermissionName)
+	}
+	metadata.PermissionGroups = buildPermissionGroupTree(permissionGroups, "", groupPermissionNamesMap)
+	walkPermissionGroupTree(metadata.PermissionGroups, func(item *PermissionGroupItem) {
+		item.Titles, item.Descriptions = translationsToMetadata(permissionGroupTranslationsMap[item.Name])
+	})
+
+	if param.RoleableType == "" {
+		return metadata, nil
+	}
+
+	var roles []*Role
+	if err := db.Model(&Role{}).
+		Scopes(notDeletedRole).
+		Where("roleable_type = ?", param.RoleableType).
+		Where("roleable_id = ?", param.RoleableID).
+		Order("id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	roleIDs := make([]int64, 0, len(roles))
+	for _, r := range roles {
+		roleIDs = append(roleIDs, r.ID)
+	}
+
+	var rolePermissionGroups []*RolePermissionGroup
+	if len(roleIDs) > 0 {
+		if err := db.Model(&RolePermissionGroup{}).
+			Where("role_id IN ?", roleIDs).
+			Order("permission_group_name").Find(&rolePermissionGroups).Error; err != nil {
+			return nil, err
+		}
+	}
+	rolePermissionGroupNamesMap := make(map[int64][]string, len(roles))
+	for _, g := range rolePermissionGroups {
+		rolePermissionGroupNamesMap[g.RoleID] = append(rolePermissionGroupNamesMap[g.RoleID], g.PermissionGroupName)
+	}
+
+	roleTranslationsMap, err := getAllTranslations(db, TranslationKindRole, param.RoleableType)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata.Roles = make([]*RolePermissionGroupItem, 0, len(roles))
+	for _, r := range roles {
+		titles, descriptions := translationsToMetadata(roleTranslationsMap[r.Name])
+		metadata.Roles = append(metadata.Roles, &RolePermissionGroupItem{
+			RoleableType:     r.RoleableType,
+			Name:             r.Name,
+			Title:            r.Title,
+			Description:      r.Description,
+			PermissionGroups: rolePermissionGroupNamesMap[r.ID],
+			Titles:           titles,
+			Descriptions:     descriptions,
+		})
+	}
+	return metadata, nil
+}
+
+// 将 locale 到翻译的映射转换为元数据中的多语言标题和描述，没有内容时返回 nil
+func translationsToMetadata(translations map[string]*PermissionTranslation) (map[string]string, map[string]string) {
+	var titles, descriptions map[string]string
+	for locale, t := range translations {
+		if t.Title != "" {
+			if titles == nil {
+				titles = make(map[string]string, len(translations))
+			}
+			titles[locale] = t.Title
+		}
+		if t.Description != "" {
+			if descriptions == nil {
+				descriptions = make(map[string]string, len(translations))
+			}
+			descriptions[locale] = t.Description
+		}
+	}
+	return titles, descriptions
+}
+
+// 将权限元数据按指定格式输出
+func EncodePermissionMetadata(w io.Writer, metadata *PermissionMetadata, format string) error {
+	switch format {
+	case MetadataFormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(metadata); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case MetadataFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(metadata)
+	default:
+		return fmt.Errorf("unsupported metadata format %s", format)
+	}
+}
+
+// 按指定格式读取权限元数据
+func DecodePermissionMetadata(r io.Reader, format string) (*PermissionMetadata, error) {
+	var metadata PermissionMetadata
+	switch format {
+	case MetadataFormatYAML:
+		if err := yaml.NewDecoder(r).Decode(&metadata); err != nil {
+			return nil, err
+		}
+	case MetadataFormatJSON:
+		if err := json.NewDecoder(r).Decode(&metadata); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported metadata format %s", format)
+	}
+	return &metadata, nil
+}
@@ -0,0 +1,111 @@
+package permission
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 对象下角色持有人数的最小值约束，比如每个应用至少保留一名管理员
+//
+// 持有人为拥有任一 RoleNames 角色，或拥有包含任一 PermissionGroups 权限组的角色的用户
+type MinHoldersRule struct {
+	RoleableType     string   `json:"roleable_type" yaml:"roleable_type"`
+	RoleNames        []string `json:"role_names" yaml:"role_names"`
+	PermissionGroups []string `json:"permission_groups" yaml:"permission_groups"`
+	MinHolders       int64    `json:"min_holders" yaml:"min_holders"`
+}
+
+func (r *MinHoldersRule) String() string {
+	var targets []string
+	if len(r.RoleNames) > 0 {
+		targets = append(targets, fmt.Sprintf("roles %v", r.RoleNames))
+	}
+	if len(r.PermissionGroups) > 0 {
+		targets = append(targets, fmt.Sprintf("permission groups %v", r.PermissionGroups))
+	}
+	return fmt.Sprintf("%s requires at least %d holders of %s", r.RoleableType, r.MinHolders, strings.Join(targets, " or "))
+}
+
+// 配置角色持有人数约束，角色、用户角色变更导致持有人数减少到最小值以下时返回 MinHoldersError
+//
+// 持有人数原本就低于最小值时（比如刚创建的对象），不减少持有人数的变更不受限制
+func WithMinHoldersRules(rules ...MinHoldersRule) PermissionServiceOption {
+	return func(s *PermissionService) {
+		s.minHoldersRules = append(s.minHoldersRules, rules...)
+	}
+}
+
+// 变更前的持有人数，用于在变更后检查约束
+type minHoldersGuard struct {
+	roleableType string
+	roleableID   int64
+	rules        []*MinHoldersRule
+	holders      []int64
+}
+
+// 记录对象下各约束变更前的持有人数，对象类型没有约束时返回 nil
+func (s *PermissionService) newMinHoldersGuard(tx *gorm.DB, roleableType string, roleableID int64) (*minHoldersGuard, error) {
+	var guard *minHoldersGuard
+	for i := range s.minHoldersRules {
+		rule := &s.minHoldersRules[i]
+		if rule.RoleableType != roleableType {
+			continue
+		}
+		holders, err := countHolders(tx, rule, roleableID)
+		if err != nil {
+			return nil, err
+		}
+		if guard == nil {
+			guard = &minHoldersGuard{roleableType: roleableType, roleableID: roleableID}
+		}
+		guard.rules = append(guard.rules, rule)
+		guard.holders = append(guard.holders, holders)
+	}
+	return guard, nil
+}
+
+// 检查变更后的持有人数
+func (g *minHoldersGuard) check(tx *gorm.DB) error {
+	if g == nil {
+		return nil
+	}
+	for i, rule := range g.rules {
+		holders, err := countHolders(tx, rule, g.roleableID)
+		if err != nil {
+			return err
+		}
+		if holders < rule.MinHolders && holders < g.holders[i] {
+			return &MinHoldersError{
+				Rule:       *rule,
+				RoleableID: g.roleableID,
+				Holders:    holders,
+			}
+		}
+	}
+	return nil
+}
+
+// 统计对象下满足约束的持有人数
+func countHolders(tx *gorm.DB, rule *MinHoldersRule, roleableID int64) (int64, error) {
+	condition := tx.Where("1 = 0")
+	if len(rule.RoleNames) > 0 {
+		condition = condition.Or("name IN ?", rule.RoleNames)
+	}
+	if len(rule.PermissionGroups) > 0 {
+		condition = condition.Or("id IN (?)", tx.Model(&RolePermissionGroup{}).Select("role_id").Where("permission_group_name IN ?", rule.PermissionGroups))
+	}
+	roleIDs := tx.Model(&Role{}).
+		Select("id").
+		Scopes(notDeletedRole).
+		Where("roleable_type = ?", rule.RoleableType).
+		Where("roleable_id = ?", roleableID).
+		Where(condition)
+
+	var count int64
+	if err := tx.Model(&UserRole{}).Distinct("user_id").Where("role_id IN (?)", roleIDs).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,191 @@
+package permission
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// 事件类型
+const (
+	EventRoleCreated      = "role.created"       // Before 为 nil，After 为 *RoleSnapshot
+	EventRoleUpdated      = "role.updated"       // Before 和 After 为 *RoleSnapshot
+	EventRoleDeleted      = "role.deleted"       // Before 为 *RoleSnapshot，After 为 nil
+	EventRoleRestored     = "role.restored"      // Before 为 nil，After 为 *RoleSnapshot
+	EventUserRolesChanged = "user_roles.changed" // Before 和 After 为 *UserRolesSnapshot
+	EventMetadataSynced   = "metadata.synced"    // Before 和 After 为 *PermissionMetadata，不包含角色，元数据没有变化时不发出
+)
+
+// 角色及其权限组
+type RoleSnapshot struct {
+	Role             *Role    `json:"role" yaml:"role"`
+	PermissionGroups []string `json:"permission_groups" yaml:"permission_groups"`
+}
+
+// 用户在某个对象下的角色
+type UserRolesSnapshot struct {
+	UserID       int64   `json:"user_id" yaml:"user_id"`
+	RoleableType string  `json:"roleable_type" yaml:"roleable_type"`
+	RoleableID   int64   `json:"roleable_id" yaml:"roleable_id"`
+	RoleIDs      []int64 `json:"role_ids" yaml:"role_ids"`
+}
+
+// 生命周期事件
+type Event struct {
+	Type   string   `json:"type" yaml:"type"`
+	Before any      `json:"before" yaml:"before"` // 变更前的数据，具体类型见事件类型说明
+	After  any      `json:"after" yaml:"after"`   // 变更后的数据
+	Tx     *gorm.DB `json:"-" yaml:"-"`           // 事件所在事务，只有事务内执行的 hook 可以使用
+}
+
+// 生命周期事件订阅者
+type Hook interface {
+	OnEvent(ctx context.Context, event *Event) error
+}
+
+type HookFunc func(ctx context.Context, event *Event) error
+
+func (f HookFunc) OnEvent(ctx context.Context, event *Event) error {
+	return f(ctx, event)
+}
+
+// 注册事务提交后执行的 hook，hook 返回的错误只记录日志
+func WithHook(hook Hook) PermissionServiceOption {
+	return func(s *PermissionService) {
+		s.hooks = append(s.hooks, hook)
+	}
+}
+
+// 注册事务内执行的 hook，hook 返回错误时事务回滚，调用方收到该错误
+//
+// SyncPresetRoles 使用调用方传入的事务，只会触发事务内 hook
+func WithTxHook(hook Hook) PermissionServiceOption {
+	return func(s *PermissionService) {
+		s.txHooks = append(s.txHooks, hook)
+	}
+}
+
+type emitFunc func(event *Event) error
+
+func (s *PermissionService) hasHooks() bool {
+	return len(s.hooks) > 0 || len(s.txHooks) > 0
+}
+
+// 在事务中执行 fn，fn 通过 emit 发出的事件会立即交给事务内 hook，事务提交后再交给提交后 hook
+func (s *PermissionService) transaction(ctx context.Context, fn func(tx *gorm.DB, emit emitFunc) error) error {
+	var events []*Event
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(tx, func(event *Event) error {
+			if err := s.runTxHooks(ctx, tx, event); err != nil {
+				return err
+			}
+			events = append(events, event)
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		for _, hook := range s.hooks {
+			if err := hook.OnEvent(ctx, event); err != nil {
+				log.Println(fmt.Errorf("permission hook event=%s err=%w", event.Type, err))
+			}
+		}
+	}
+	return nil
+}
+
+func (s *PermissionService) runTxHooks(ctx context.Context, tx *gorm.DB, event *Event) error {
+	for _, hook := range s.txHooks {
+		event.Tx = tx
+		err := hook.OnEvent(ctx, event)
+		event.Tx = nil
+		if err != nil {
+			return fmt.Errorf("permission hook event=%s err=%w", event.Type, err)
+		}
+	}
+	return nil
+}
+
+// 获取角色快照，角色不存在时返回 nil
+func (s *PermissionService) roleSnapshot(tx *gorm.DB, roleID int64) (*RoleSnapshot, error) {
+	var role Role
+	if err := tx.Where("id = ?", roleID).Limit(1).Find(&role).Error; err != nil {
+		return nil, err
+	}
+	if role.ID == 0 {
+		return nil, nil
+	}
+	snapshot := &RoleSnapshot{Role: &role}
+	if err := tx.Model(&RolePermissionGroup{}).
+		Where("role_id = ?", roleID).
+		Order("permission_group_name").
+		Pluck("permission_group_name", &snapshot.PermissionGroups).Error; err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// 按名称获取未删除角色的快照，角色不存在时返回 nil
+func (s *PermissionService) roleSnapshotByName(tx *gorm.DB, roleableType string, roleableID int64, name string) (*RoleSnapshot, error) {
+	var roleID int64
+	if err := tx.Model(&Role{}).
+		Scopes(notDeletedRole).
+		Where("roleable_type = ?", roleableType).
+		Where("roleable_id = ?", roleableID).
+		Where("name = ?", name).
+		Limit(1).Pluck("id", &roleID).Error; err != nil {
+		return nil, err
+	}
+	if roleID == 0 {
+		return nil, nil
+	}
+	return s.roleSnapshot(tx, roleID)
+}
+
+// 发出角色事件，before 为 nil 时为创建事件，否则为更新事件，权限组没有变化的更新不发出事件
+func (s *PermissionService) emitRoleSaved(tx *gorm.DB, emit emitFunc, before *RoleSnapshot, roleID int64) error {
+	if !s.hasHooks() {
+		return nil
+	}
+	after, err := s.roleSnapshot(tx, roleID)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return emit(&Event{Type: EventRoleCreated, After: after})
+	}
+	if *before.Role == *after.Role && slices.Equal(before.PermissionGroups, after.PermissionGroups) {
+		return nil
+	}
+	return emit(&Event{Type: EventRoleUpdated, Before: before, After: after})
+}
+
+func (s *PermissionService) userRolesSnapshot(tx *gorm.DB, userID, roleableID int64, roleableType string) (*UserRolesSnapshot, error) {
+	roleIDs, err := s.getUserRoleIDs(tx, userID, roleableID, roleableType)
+	if err != nil {
+		return nil, err
+	}
+	return &UserRolesSnapshot{
+		UserID:       userID,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		RoleIDs:      roleIDs,
+	}, nil
+}
+
+// 发出用户角色变更事件，角色没有变化时不发出事件
+func (s *PermissionService) emitUserRolesChanged(tx *gorm.DB, emit emitFunc, before *UserRolesSnapshot) error {
+	after, err := s.userRolesSnapshot(tx, before.UserID, before.RoleableID, before.RoleableType)
+	if err != nil {
+		return err
+	}
+	if slices.Equal(before.RoleIDs, after.RoleIDs) {
+		return nil
+	}
+	return emit(&Event{Type: EventUserRolesChanged, Before: before, After: after})
+}
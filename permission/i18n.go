@@ -0,0 +1,268 @@
+package permission
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 多语言翻译类型
+const (
+	TranslationKindPermission      = "permission"
+	TranslationKindPermissionGroup = "permission_group"
+	TranslationKindRole            = "role"
+)
+
+// 配置回退 locale，请求的 locale 及其语言部分（比如 zh-TW 的 zh）都没有翻译时依次尝试，最后使用默认的 Title
+func WithFallbackLocales(locales ...string) PermissionServiceOption {
+	return func(s *PermissionService) {
+		for _, locale := range locales {
+			s.fallbackLocales = append(s.fallbackLocales, normalizeLocale(locale))
+		}
+	}
+}
+
+// 统一 locale 格式，小写并使用 - 分隔
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// 可选的 locale 参数，未传入时为空
+func optionalLocale(locale []string) string {
+	if len(locale) == 0 {
+		return ""
+	}
+	return locale[0]
+}
+
+// 按回退规则返回依次尝试的 locale，locale 为空时返回 nil
+func (s *PermissionService) candidateLocales(locale string) []string {
+	locale = normalizeLocale(locale)
+	if locale == "" {
+		return nil
+	}
+	locales := []string{locale}
+	if language, _, ok := strings.Cut(locale, "-"); ok {
+		locales = append(locales, language)
+	}
+	for _, fallback := range s.fallbackLocales {
+		var existed bool
+		for _, l := range locales {
+			if l == fallback {
+				existed = true
+				break
+			}
+		}
+		if !existed {
+			locales = append(locales, fallback)
+		}
+	}
+	return locales
+}
+
+// 校验多语言标题或描述的 locale
+func validateLocales(subject string, translations map[string]string) []error {
+	var errs []error
+	localesMap := make(map[string]string, len(translations))
+	for locale := range translations {
+		normalized := normalizeLocale(locale)
+		if normalized == "" {
+			errs = append(errs, fmt.Errorf("%s locale empty", subject))
+			continue
+		}
+		if existed, ok := localesMap[normalized]; ok {
+			errs = append(errs, fmt.Errorf("%s locale:%s and locale:%s reduplicated", subject, existed, locale))
+		}
+		localesMap[normalized] = locale
+	}
+	return errs
+}
+
+// 元数据中的多语言标题和描述
+func (s *PermissionService) metadataTranslations() []*PermissionTranslation {
+	var translations []*PermissionTranslation
+	add := func(kind, roleableType, name string, titles, descriptions map[string]string) {
+		translationsMap := make(map[string]*PermissionTranslation, len(titles))
+		get := func(locale string) *PermissionTranslation {
+			locale = normalizeLocale(locale)
+			t, ok := translationsMap[locale]
+			if !ok {
+				t = &PermissionTranslation{Kind: kind, RoleableType: roleableType, Name: name, Locale: locale}
+				translationsMap[locale] = t
+				translations = append(translations, t)
+			}
+			return t
+		}
+		for locale, title := range titles {
+			get(locale).Title = title
+		}
+		for locale, description := range descriptions {
+			get(locale).Description = description
+		}
+	}
+
+	for _, p := range s.metadata.Permissions {
+		add(TranslationKindPermission, "", p.Name, p.Titles, p.Descriptions)
+	}
+	var addPermissionGroups func(groups []*PermissionGroupItem)
+	addPermissionGroups = func(groups []*PermissionGroupItem) {
+		for _, g := range groups {
+			add(TranslationKindPermissionGroup, "", g.Name, g.Titles, g.Descriptions)
+			addPermissionGroups(g.PermissionGroups)
+		}
+	}
+	addPermissionGroups(s.metadata.PermissionGroups)
+	for _, r := range s.metadata.Roles {
+		add(TranslationKindRole, r.RoleableType, r.Name, r.Titles, r.Descriptions)
+	}
+	return translations
+}
+
+// 同步多语言标题和描述，使用元数据中的翻译替换全部已有翻译
+func (s *PermissionService) syncTranslations(tx *gorm.DB) error {
+	if err := tx.Where("1 = 1").Delete(&PermissionTranslation{}).Error; err != nil {
+		return err
+	}
+	translations := s.metadataTranslations()
+	if len(translations) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(translations, defaultBulkBatchSize).Error
+}
+
+// 获取某个类型下的全部翻译，key 为 name，value 为 locale 到翻译的映射
+func getAllTranslations(db *gorm.DB, kind, roleableType string) (map[string]map[string]*PermissionTranslation, error) {
+	var translations []*PermissionTranslation
+	if err := db.Model(&PermissionTranslation{}).
+		Where("kind = ?", kind).
+		Where("roleable_type = ?", roleableType).
+		Find(&translations).Error; err != nil {
+		return nil, err
+	}
+	translationsMap := make(map[string]map[string]*PermissionTranslation)
+	for _, t := range translations {
+		if translationsMap[t.Name] == nil {
+			translationsMap[t.Name] = make(map[string]*PermissionTranslation)
+		}
+		translationsMap[t.Name][t.Locale] = t
+	}
+	return translationsMap, nil
+}
+
+// 按回退规则获取 names 在 locale 下的翻译，key 为 name，没有翻译的 name 不在结果中
+//
+// 标题和描述分别回退，比如 ja 只翻译了标题时，描述使用回退 locale 的翻译
+func (s *PermissionService) getTranslations(db *gorm.DB, kind, roleableType string, names []string, locale string) (map[string]*PermissionTranslation, error) {
+	locales := s.candidateLocales(locale)
+	if len(locales) == 0 || len(names) == 0 {
+		return nil, nil
+	}
+
+	var translations []*PermissionTranslation
+	if err := db.Model(&PermissionTranslation{}).
+		Where("kind = ?", kind).
+		Where("roleable_type = ?", roleableType).
+		Where("name IN ?", names).
+		Where("locale IN ?", locales).
+		Find(&translations).Error; err != nil {
+		return nil, err
+	}
+	translationsMap := make(map[string]map[string]*PermissionTranslation, len(names))
+	for _, t := range translations {
+		if translationsMap[t.Name] == nil {
+			translationsMap[t.Name] = make(map[string]*PermissionTranslation, len(locales))
+		}
+		translationsMap[t.Name][t.Locale] = t
+	}
+
+	resolved := make(map[string]*PermissionTranslation, len(translationsMap))
+	for name, localeTranslationsMap := range translationsMap {
+		translation := &PermissionTranslation{Kind: kind, RoleableType: roleableType, Name: name}
+		for _, l := range locales {
+			t, ok := localeTranslationsMap[l]
+			if !ok {
+				continue
+			}
+			if translation.Title == "" {
+				translation.Title = t.Title
+			}
+			if translation.Description == "" {
+				translation.Description = t.Description
+			}
+		}
+		resolved[name] = translation
+	}
+	return resolved, nil
+}
+
+// 将权限树中的标题和描述替换为 locale 下的翻译，locale 为空或没有翻译时保留默认值
+func (s *PermissionService) LocalizePermissionGroupTree(ctx context.Context, tree []*PermissionGroupItem, locale string) error {
+	var names []string
+	walkPermissionGroupTree(tree, func(item *PermissionGroupItem) {
+		names = append(names, item.Name)
+	})
+	translations, err := s.getTranslations(s.db.WithContext(ctx), TranslationKindPermissionGroup, "", names, locale)
+	if err != nil {
+		return err
+	}
+	walkPermissionGroupTree(tree, func(item *PermissionGroupItem) {
+		t, ok := translations[item.Name]
+		if !ok {
+			return
+		}
+		if t.Title != "" {
+			item.Title = t.Title
+		}
+		if t.Description != "" {
+			item.Description = t.Description
+		}
+	})
+	return nil
+}
+
+// 将预置角色的标题和描述替换为 locale 下的翻译，locale 为空或没有翻译时保留原值
+//
+// 只替换和元数据中默认值一致的标题和描述，被用户修改过的标题和描述保持不变
+func (s *PermissionService) LocalizeRoles(ctx context.Context, roles []*Role, locale string) error {
+	if len(s.candidateLocales(locale)) == 0 {
+		return nil
+	}
+
+	presetRolesMap := make(map[string]*RolePermissionGroupItem, len(s.metadata.Roles))
+	for _, r := range s.metadata.Roles {
+		presetRolesMap[r.RoleableType+"_"+r.Name] = r
+	}
+	roleableTypeNamesMap := make(map[string][]string)
+	for _, role := range roles {
+		if _, ok := presetRolesMap[role.RoleableType+"_"+role.Name]; ok {
+			roleableTypeNamesMap[role.RoleableType] = append(roleableTypeNamesMap[role.RoleableType], role.Name)
+		}
+	}
+
+	db := s.db.WithContext(ctx)
+	for roleableType, names := range roleableTypeNamesMap {
+		translations, err := s.getTranslations(db, TranslationKindRole, roleableType, names, locale)
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			if role.RoleableType != roleableType {
+				continue
+			}
+			t, ok := translations[role.Name]
+			if !ok {
+				continue
+			}
+			preset := presetRolesMap[role.RoleableType+"_"+role.Name]
+			if t.Title != "" && role.Title == preset.Title {
+				role.Title = t.Title
+			}
+			if t.Description != "" && role.Description == preset.Description {
+				role.Description = t.Description
+			}
+		}
+	}
+	return nil
+}
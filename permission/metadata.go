@@ -0,0 +1,264 @@
+package permission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 校验权限元数据，返回所有发现的问题
+func (m *PermissionMetadata) Validate() error {
+	var errs []error
+
+	permissionKeysMap := make(map[string]struct{}, len(m.Permissions))
+	permissionResourceActionKeysMap := make(map[string]struct{}, len(m.Permissions))
+	for _, p := range m.Permissions {
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("permission domain:%s + resource:%s + action:%s name empty", p.Domain, p.Resource, p.Action))
+		}
+		if _, ok := permissionKeysMap[p.Name]; ok {
+			errs = append(errs, fmt.Errorf("permission name:%s reduplicated", p.Name))
+		}
+		permissionKeysMap[p.Name] = struct{}{}
+
+		resourceActionKey := fmt.Sprintf("%s_%s_%s", p.Domain, p.Resource, p.Action)
+		if _, ok := permissionResourceActionKeysMap[resourceActionKey]; ok {
+			errs = append(errs, fmt.Errorf("permission domain:%s + resource:%s + action:%s reduplicated", p.Domain, p.Resource, p.Action))
+		}
+		permissionResourceActionKeysMap[resourceActionKey] = struct{}{}
+		errs = append(errs, validateLocales(fmt.Sprintf("permission name:%s titles", p.Name), p.Titles)...)
+		errs = append(errs, validateLocales(fmt.Sprintf("permission name:%s descriptions", p.Name), p.Descriptions)...)
+	}
+
+	var validatePermissionGroupLocales func(groups []*PermissionGroupItem)
+	validatePermissionGroupLocales = func(groups []*PermissionGroupItem) {
+		for _, g := range groups {
+			errs = append(errs, validateLocales(fmt.Sprintf("permission group name:%s titles", g.Name), g.Titles)...)
+			errs = append(errs, validateLocales(fmt.Sprintf("permission group name:%s descriptions", g.Name), g.Descriptions)...)
+			validatePermissionGroupLocales(g.PermissionGroups)
+		}
+	}
+	validatePermissionGroupLocales(m.PermissionGroups)
+
+	permissionGroups, permissionGroupPermissions := flattenPermissionGroups(m.PermissionGroups, "")
+	permissionGroupKeysMap := make(map[string]struct{}, len(permissionGroups))
+	for _, g := range permissionGroups {
+		if g.Name == "" {
+			errs = append(errs, fmt.Errorf("permission group name empty, parent_name:%s", g.ParentName))
+		}
+		if _, ok := permissionGroupKeysMap[g.Name]; ok {
+			errs = append(errs, fmt.Errorf("permission group name:%s reduplicated", g.Name))
+		}
+		permissionGroupKeysMap[g.Name] = struct{}{}
+	}
+	for _, p := range permissionGroupPermissions {
+		if _, ok := permissionKeysMap[p.PermissionName]; !ok {
+			errs = append(errs, fmt.Errorf("permission group name:%s permission:%s not existed", p.PermissionGroupName, p.PermissionName))
+		}
+	}
+
+	roleKeysMap := make(map[string]struct{}, len(m.Roles))
+	for _, r := range m.Roles {
+		roleKey := fmt.Sprintf("%s_%s", r.RoleableType, r.Name)
+		if _, ok := roleKeysMap[roleKey]; ok {
+			errs = append(errs, fmt.Errorf("role roleable_type:%s + name:%s reduplicated", r.RoleableType, r.Name))
+		}
+		roleKeysMap[roleKey] = struct{}{}
+
+		if len(r.PermissionGroups) == 0 {
+			errs = append(errs, fmt.Errorf("role roleable_type:%s + name:%s: %w", r.RoleableType, r.Name, ErrEmptyPermissionGroups))
+		}
+		for _, groupKey := range r.PermissionGroups {
+			if _, ok := permissionGroupKeysMap[groupKey]; !ok {
+				errs = append(errs, fmt.Errorf("role roleable_type:%s + name:%s permission group:%s not existed", r.RoleableType, r.Name, groupKey))
+			}
+		}
+		errs = append(errs, validateLocales(fmt.Sprintf("role roleable_type:%s + name:%s titles", r.RoleableType, r.Name), r.Titles)...)
+		errs = append(errs, validateLocales(fmt.Sprintf("role roleable_type:%s + name:%s descriptions", r.RoleableType, r.Name), r.Descriptions)...)
+	}
+
+	roleConstraintKeysMap := make(map[string]struct{}, len(m.RoleConstraints))
+	for _, c := range m.RoleConstraints {
+		errs = append(errs, c.validate()...)
+		roleConstraintKey := fmt.Sprintf("%s_%s", c.RoleableType, c.Name)
+		if _, ok := roleConstraintKeysMap[roleConstraintKey]; ok {
+			errs = append(errs, fmt.Errorf("role constraint roleable_type:%s + name:%s reduplicated", c.RoleableType, c.Name))
+		}
+		roleConstraintKeysMap[roleConstraintKey] = struct{}{}
+	}
+
+	return errors.Join(errs...)
+}
+
+// 将嵌套的权限组展开为权限组和权限组权限列表
+func flattenPermissionGroups(groups []*PermissionGroupItem, parentName string) ([]*PermissionGroup, []*PermissionGroupPermission) {
+	var permissionGroups []*PermissionGroup
+	var permissionGroupPermissions []*PermissionGroupPermission
+	for i, g := range groups {
+		permissionGroups = append(permissionGroups, &PermissionGroup{
+			Name:        g.Name,
+			Domain:      g.Domain,
+			Title:       g.Title,
+			Description: g.Description,
+			GroupIndex:  i,
+			ParentName:  parentName,
+		})
+		for _, permissionKey := range g.Permissions {
+			permissionGroupPermissions = append(permissionGroupPermissions, &PermissionGroupPermission{
+				PermissionGroupName: g.Name,
+				PermissionName:      permissionKey,
+			})
+		}
+		childGroups, childGroupPermissions := flattenPermissionGroups(g.PermissionGroups, g.Name)
+		permissionGroups = append(permissionGroups, childGroups...)
+		permissionGroupPermissions = append(permissionGroupPermissions, childGroupPermissions...)
+	}
+	return permissionGroups, permissionGroupPermissions
+}
+
+// 同步权限元数据的变更计划
+type SyncPlan struct {
+	CreatePermissions []string `json:"create_permissions" yaml:"create_permissions"`
+	UpdatePermissions []string `json:"update_permissions" yaml:"update_permissions"`
+	DeletePermissions []string `json:"delete_permissions" yaml:"delete_permissions"`
+
+	CreatePermissionGroups []string `json:"create_permission_groups" yaml:"create_permission_groups"`
+	UpdatePermissionGroups []string `json:"update_permission_groups" yaml:"update_permission_groups"`
+	DeletePermissionGroups []string `json:"delete_permission_groups" yaml:"delete_permission_groups"`
+
+	CreatePermissionGroupPermissions []*PermissionGroupPermission `json:"create_permission_group_permissions" yaml:"create_permission_group_permissions"`
+	DeletePermissionGroupPermissions []*PermissionGroupPermission `json:"delete_permission_group_permissions" yaml:"delete_permission_group_permissions"`
+}
+
+// 是否没有任何变更
+func (p *SyncPlan) IsEmpty() bool {
+	return len(p.CreatePermissions) == 0 && len(p.UpdatePermissions) == 0 && len(p.DeletePermissions) == 0 &&
+		len(p.CreatePermissionGroups) == 0 && len(p.UpdatePermissionGroups) == 0 && len(p.DeletePermissionGroups) == 0 &&
+		len(p.CreatePermissionGroupPermissions) == 0 && len(p.DeletePermissionGroupPermissions) == 0
+}
+
+// 比较权限元数据和数据库当前状态，生成 SyncPermissionMetadata 将要执行的变更，不修改数据库
+func (s *PermissionService) PlanSyncPermissionMetadata(ctx context.Context) (*SyncPlan, error) {
+	return s.planSyncPermissionMetadata(s.db.WithContext(ctx))
+}
+
+func (s *PermissionService) planSyncPermissionMetadata(db *gorm.DB) (*SyncPlan, error) {
+	var plan SyncPlan
+
+	var existedPermissions []*Permission
+	if err := db.Order("name").Find(&existedPermissions).Error; err != nil {
+		return nil, err
+	}
+	existedPermissionsMap := make(map[string]*Permission, len(existedPermissions))
+	for _, p := range existedPermissions {
+		existedPermissionsMap[p.Name] = p
+	}
+	permissionKeysMap := make(map[string]struct{}, len(s.metadata.Permissions))
+	for _, p := range s.metadata.Permissions {
+		permissionKeysMap[p.Name] = struct{}{}
+		existed, ok := existedPermissionsMap[p.Name]
+		if !ok {
+			plan.CreatePermissions = append(plan.CreatePermissions, p.Name)
+		} else if existed.Title != p.Title || existed.Description != p.Description || existed.Domain != p.Domain || existed.Resource != p.Resource || existed.Action != p.Action {
+			plan.UpdatePermissions = append(plan.UpdatePermissions, p.Name)
+		}
+	}
+	for _, p := range existedPermissions {
+		if _, ok := permissionKeysMap[p.Name]; !ok {
+			plan.DeletePermissions = append(plan.DeletePermissions, p.Name)
+		}
+	}
+
+	var existedPermissionGroups []*PermissionGroup
+	if err := db.Order("name").Find(&existedPermissionGroups).Error; err != nil {
+		return nil, err
+	}
+	existedPermissionGroupsMap := make(map[string]*PermissionGroup, len(existedPermissionGroups))
+	for _, g := range existedPermissionGroups {
+		existedPermissionGroupsMap[g.Name] = g
+	}
+	permissionGroups, permissionGroupPermissions := flattenPermissionGroups(s.metadata.PermissionGroups, "")
+	permissionGroupKeysMap := make(map[string]struct{}, len(permissionGroups))
+	for _, g := range permissionGroups {
+		permissionGroupKeysMap[g.Name] = struct{}{}
+		existed, ok := existedPermissionGroupsMap[g.Name]
+		if !ok {
+			plan.CreatePermissionGroups = append(plan.CreatePermissionGroups, g.Name)
+		} else if existed.Title != g.Title || existed.Description != g.Description || existed.Domain != g.Domain || existed.GroupIndex != g.GroupIndex || existed.ParentName != g.ParentName {
+			plan.UpdatePermissionGroups = append(plan.UpdatePermissionGroups, g.Name)
+		}
+	}
+	for _, g := range existedPermissionGroups {
+		if _, ok := permissionGroupKeysMap[g.Name]; !ok {
+			plan.DeletePermissionGroups = append(plan.DeletePermissionGroups, g.Name)
+		}
+	}
+
+	var existedPermissionGroupPermissions []*PermissionGroupPermission
+	if err := db.Order("permission_group_name").Order("permission_name").Find(&existedPermissionGroupPermissions).Error; err != nil {
+		return nil, err
+	}
+	existedPermissionGroupPermissionsMap := make(map[string]struct{}, len(existedPermissionGroupPermissions))
+	for _, p := range existedPermissionGroupPermissions {
+		existedPermissionGroupPermissionsMap[p.PermissionGroupName+"_"+p.PermissionName] = struct{}{}
+	}
+	// 和 SyncPermissionMetadata 一致，元数据中权限列表为空的权限组保留已有的权限关系
+	syncedPermissionGroupsMap := make(map[string]struct{}, len(permissionGroups))
+	permissionGroupPermissionKeysMap := make(map[string]struct{}, len(permissionGroupPermissions))
+	for _, p := range permissionGroupPermissions {
+		syncedPermissionGroupsMap[p.PermissionGroupName] = struct{}{}
+		key := p.PermissionGroupName + "_" + p.PermissionName
+		permissionGroupPermissionKeysMap[key] = struct{}{}
+		if _, ok := existedPermissionGroupPermissionsMap[key]; !ok {
+			plan.CreatePermissionGroupPermissions = append(plan.CreatePermissionGroupPermissions, p)
+		}
+	}
+	for _, p := range existedPermissionGroupPermissions {
+		_, keptGroup := permissionGroupKeysMap[p.PermissionGroupName]
+		if _, synced := syncedPermissionGroupsMap[p.PermissionGroupName]; keptGroup && !synced {
+			continue
+		}
+		if _, ok := permissionGroupPermissionKeysMap[p.PermissionGroupName+"_"+p.PermissionName]; !ok {
+			plan.DeletePermissionGroupPermissions = append(plan.DeletePermissionGroupPermissions, p)
+		}
+	}
+
+	return &plan, nil
+}
+
+// 元数据和数据库当前状态是否不同，包括权限、权限组和多语言翻译
+func (s *PermissionService) metadataChanged(tx *gorm.DB) (bool, error) {
+	plan, err := s.planSyncPermissionMetadata(tx)
+	if err != nil {
+		return false, err
+	}
+	if !plan.IsEmpty() {
+		return true, nil
+	}
+
+	var existedTranslations []*PermissionTranslation
+	if err := tx.Find(&existedTranslations).Error; err != nil {
+		return false, err
+	}
+	translations := s.metadataTranslations()
+	if len(existedTranslations) != len(translations) {
+		return true, nil
+	}
+	translationKey := func(t *PermissionTranslation) string {
+		return strings.Join([]string{t.Kind, t.RoleableType, t.Name, t.Locale}, "\x00")
+	}
+	existedTranslationsMap := make(map[string]*PermissionTranslation, len(existedTranslations))
+	for _, t := range existedTranslations {
+		existedTranslationsMap[translationKey(t)] = t
+	}
+	for _, t := range translations {
+		existed, ok := existedTranslationsMap[translationKey(t)]
+		if !ok || existed.Title != t.Title || existed.Description != t.Description {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -1,6 +1,7 @@
 CREATE TABLE `permissions` (
   `name` varchar(256) NOT NULL,
   `title` longtext,
+  `description` longtext,
   `domain` varchar(128) DEFAULT NULL,
   `resource` varchar(256) DEFAULT NULL,
   `action` varchar(64) DEFAULT NULL,
@@ -14,6 +15,7 @@ CREATE TABLE `permission_groups` (
   `name` varchar(256) NOT NULL,
   `domain` longtext,
   `title` longtext,
+  `description` longtext,
   `group_index` bigint(20) DEFAULT NULL,
   `parent_name` longtext,
   `created_at` bigint(20) DEFAULT NULL,
@@ -37,10 +39,12 @@ CREATE TABLE `roles` (
   `title` longtext,
   `description` longtext,
   `creator_user_id` bigint(20) DEFAULT NULL,
+  `version` bigint(20) DEFAULT 1,
   `created_at` bigint(20) DEFAULT NULL,
   `updated_at` bigint(20) DEFAULT NULL,
+  `deleted_at` bigint(20) DEFAULT 0,
   PRIMARY KEY (`id`),
-  UNIQUE KEY `idx_roles_name` (`roleable_type`,`roleable_id`,`name`)
+  UNIQUE KEY `idx_roles_name` (`roleable_type`,`roleable_id`,`name`,`deleted_at`)
 ) ENGINE=InnoDB AUTO_INCREMENT=4 DEFAULT CHARSET=utf8mb4;
 
 
@@ -57,4 +61,38 @@ CREATE TABLE `user_roles` (
   `role_id` bigint(20) NOT NULL,
   `created_at` bigint(20) DEFAULT NULL,
   PRIMARY KEY (`user_id`,`role_id`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4
\ No newline at end of file
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `user_roles_versions` (
+  `user_id` bigint(20) NOT NULL,
+  `roleable_type` varchar(128) NOT NULL,
+  `roleable_id` bigint(20) NOT NULL,
+  `version` bigint(20) DEFAULT NULL,
+  PRIMARY KEY (`user_id`,`roleable_type`,`roleable_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+CREATE TABLE `permission_translations` (
+  `kind` varchar(32) NOT NULL,
+  `roleable_type` varchar(128) NOT NULL,
+  `name` varchar(256) NOT NULL,
+  `locale` varchar(32) NOT NULL,
+  `title` longtext,
+  `description` longtext,
+  `created_at` bigint(20) DEFAULT NULL,
+  PRIMARY KEY (`kind`,`roleable_type`,`name`,`locale`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 使用 PollingChangeNotifier 时需要
+CREATE TABLE `permission_changes` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `type` varchar(64) DEFAULT NULL,
+  `roleable_type` varchar(128) DEFAULT NULL,
+  `roleable_id` bigint(20) DEFAULT NULL,
+  `role_id` bigint(20) DEFAULT NULL,
+  `user_id` bigint(20) DEFAULT NULL,
+  `created_at` bigint(20) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_permission_changes_created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
@@ -1,6 +1,7 @@
 CREATE TABLE permissions (
   name character varying(256) PRIMARY KEY,
   title text,
+  description text,
   domain character varying(128),
   resource character varying(256),
   action character varying(64),
@@ -14,6 +15,7 @@ CREATE TABLE permission_groups (
   name character varying(256) PRIMARY KEY,
   domain text,
   title text,
+  description text,
   group_index bigint,
   parent_name text,
   created_at bigint
@@ -38,11 +40,13 @@ CREATE TABLE roles (
   title text,
   description text,
   creator_user_id bigint,
+  version bigint DEFAULT 1,
   created_at bigint,
-  updated_at bigint
+  updated_at bigint,
+  deleted_at bigint DEFAULT 0
 );
 CREATE UNIQUE INDEX roles_pkey ON roles(id int8_ops);
-CREATE UNIQUE INDEX idx_roles_name ON roles(roleable_type text_ops,roleable_id int8_ops,name text_ops);
+CREATE UNIQUE INDEX idx_roles_name ON roles(roleable_type text_ops,roleable_id int8_ops,name text_ops,deleted_at int8_ops);
 
 
 CREATE TABLE role_permission_groups (
@@ -60,4 +64,35 @@ CREATE TABLE user_roles (
   created_at bigint,
   CONSTRAINT user_roles_pkey PRIMARY KEY (user_id, role_id)
 );
-CREATE UNIQUE INDEX user_roles_pkey ON user_roles(user_id int8_ops,role_id int8_ops);
\ No newline at end of file
+CREATE UNIQUE INDEX user_roles_pkey ON user_roles(user_id int8_ops,role_id int8_ops);
+
+CREATE TABLE user_roles_versions (
+  user_id bigint,
+  roleable_type character varying(128),
+  roleable_id bigint,
+  version bigint,
+  CONSTRAINT user_roles_versions_pkey PRIMARY KEY (user_id, roleable_type, roleable_id)
+);
+
+CREATE TABLE permission_translations (
+  kind character varying(32),
+  roleable_type character varying(128),
+  name character varying(256),
+  locale character varying(32),
+  title text,
+  description text,
+  created_at bigint,
+  CONSTRAINT permission_translations_pkey PRIMARY KEY (kind, roleable_type, name, locale)
+);
+
+-- 使用 PollingChangeNotifier 时需要
+CREATE TABLE permission_changes (
+  id BIGSERIAL PRIMARY KEY,
+  type character varying(64),
+  roleable_type character varying(128),
+  roleable_id bigint,
+  role_id bigint,
+  user_id bigint,
+  created_at bigint
+);
+CREATE INDEX idx_permission_changes_created_at ON permission_changes(created_at int8_ops);
\ No newline at end of file
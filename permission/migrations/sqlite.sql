@@ -1,6 +1,7 @@
 CREATE TABLE `permissions` (
   `name` text,
   `title` text,
+  `description` text,
   `domain` text,
   `resource` text,
   `action` text,
@@ -14,6 +15,7 @@ CREATE TABLE `permission_groups` (
   `name` text,
   `domain` text,
   `title` text,
+  `description` text,
   `group_index` integer,
   `parent_name` text,
   `created_at` integer,
@@ -37,10 +39,12 @@ CREATE TABLE `roles` (
   `title` text,
   `description` text,
   `creator_user_id` integer,
+  `version` integer DEFAULT 1,
   `created_at` integer,
-  `updated_at` integer
+  `updated_at` integer,
+  `deleted_at` integer DEFAULT 0
 );
-CREATE UNIQUE INDEX `idx_roles_name` ON `roles`(`roleable_type`,`roleable_id`,`name`);
+CREATE UNIQUE INDEX `idx_roles_name` ON `roles`(`roleable_type`,`roleable_id`,`name`,`deleted_at`);
 
 
 CREATE TABLE `role_permission_groups` (
@@ -56,4 +60,38 @@ CREATE TABLE `user_roles` (
   `role_id` integer,
   `created_at` integer,
   PRIMARY KEY (`user_id`,`role_id`)
-);
\ No newline at end of file
+);
+
+
+CREATE TABLE `user_roles_versions` (
+  `user_id` integer,
+  `roleable_type` text,
+  `roleable_id` integer,
+  `version` integer,
+  PRIMARY KEY (`user_id`,`roleable_type`,`roleable_id`)
+);
+
+
+CREATE TABLE `permission_translations` (
+  `kind` text,
+  `roleable_type` text,
+  `name` text,
+  `locale` text,
+  `title` text,
+  `description` text,
+  `created_at` integer,
+  PRIMARY KEY (`kind`,`roleable_type`,`name`,`locale`)
+);
+
+
+-- 使用 PollingChangeNotifier 时需要
+CREATE TABLE `permission_changes` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `type` text,
+  `roleable_type` text,
+  `roleable_id` integer,
+  `role_id` integer,
+  `user_id` integer,
+  `created_at` integer
+);
+CREATE INDEX `idx_permission_changes_created_at` ON `permission_changes`(`created_at`);
\ No newline at end of file
@@ -0,0 +1,3 @@
+-- 从权限和权限组没有描述的版本升级，使用 PermissionService.Migrate 时会自动执行
+ALTER TABLE `permissions` ADD COLUMN `description` longtext AFTER `title`;
+ALTER TABLE `permission_groups` ADD COLUMN `description` longtext AFTER `title`;
@@ -0,0 +1,5 @@
+-- 从不支持角色软删除的版本升级，使用 PermissionService.Migrate 时会自动执行
+ALTER TABLE `roles`
+  ADD COLUMN `deleted_at` bigint(20) DEFAULT 0,
+  DROP INDEX `idx_roles_name`,
+  ADD UNIQUE KEY `idx_roles_name` (`roleable_type`,`roleable_id`,`name`,`deleted_at`);
@@ -0,0 +1,4 @@
+-- 从不支持角色软删除的版本升级，使用 PermissionService.Migrate 时会自动执行
+ALTER TABLE roles ADD COLUMN deleted_at bigint DEFAULT 0;
+DROP INDEX idx_roles_name;
+CREATE UNIQUE INDEX idx_roles_name ON roles(roleable_type text_ops,roleable_id int8_ops,name text_ops,deleted_at int8_ops);
@@ -2,22 +2,24 @@ package permission
 
 // 基础权限
 type Permission struct {
-	Name     string `json:"name" yaml:"name" gorm:"primarykey;autoIncrement:false;size:256;"`               // 英文唯一标识
-	Title    string `json:"title" yaml:"title"`                                                             // 中文标题
-	Domain   string `json:"domain" yaml:"domain" gorm:"uniqueIndex:idx_permissions_resource;size:128;"`     // 可用于系统识别，比如 fa, fb
-	Resource string `json:"resource" yaml:"resource" gorm:"uniqueIndex:idx_permissions_resource;size:256;"` // 比如 api/v1/posts
-	Action   string `json:"action" yaml:"action" gorm:"uniqueIndex:idx_permissions_resource;size:64;"`      // 比如 get, post, put, delete 等
+	Name        string `json:"name" yaml:"name" gorm:"primarykey;autoIncrement:false;size:256;"`               // 英文唯一标识
+	Title       string `json:"title" yaml:"title"`                                                             // 中文标题
+	Description string `json:"description" yaml:"description"`                                                 // 中文描述
+	Domain      string `json:"domain" yaml:"domain" gorm:"uniqueIndex:idx_permissions_resource;size:128;"`     // 可用于系统识别，比如 fa, fb
+	Resource    string `json:"resource" yaml:"resource" gorm:"uniqueIndex:idx_permissions_resource;size:256;"` // 比如 api/v1/posts
+	Action      string `json:"action" yaml:"action" gorm:"uniqueIndex:idx_permissions_resource;size:64;"`      // 比如 get, post, put, delete 等
 
 	CreatedAt int64 `gorm:"autoCreateTime:milli"`
 }
 
 // 权限组
 type PermissionGroup struct {
-	Name       string `json:"name" yaml:"name" gorm:"primaryKey;autoIncrement:false;size:256;"` // 英文唯一标识
-	Domain     string `json:"domain" yaml:"domain"`                                             // 可用于菜单范围识别，比如团队，应用，空间
-	Title      string `json:"title" yaml:"title"`                                               // 中文标题
-	GroupIndex int    `json:"group_index" yaml:"group_index"`                                   // 用于菜单排序
-	ParentName string `json:"parent_name" yaml:"parent_name"`                                   // 为空代表顶级菜单
+	Name        string `json:"name" yaml:"name" gorm:"primaryKey;autoIncrement:false;size:256;"` // 英文唯一标识
+	Domain      string `json:"domain" yaml:"domain"`                                             // 可用于菜单范围识别，比如团队，应用，空间
+	Title       string `json:"title" yaml:"title"`                                               // 中文标题
+	Description string `json:"description" yaml:"description"`                                   // 中文描述
+	GroupIndex  int    `json:"group_index" yaml:"group_index"`                                   // 用于菜单排序
+	ParentName  string `json:"parent_name" yaml:"parent_name"`                                   // 为空代表顶级菜单
 
 	CreatedAt int64 `gorm:"autoCreateTime:milli"`
 }
@@ -37,12 +39,14 @@ type Role struct {
 	RoleableID   int64  `json:"roleable_id" yaml:"roleable_id" gorm:"uniqueIndex:idx_roles_name;"`              // 角色
 	Name         string `json:"name" yaml:"name" gorm:"uniqueIndex:idx_roles_name;size:256;"`                   // 英文唯一标识
 
-	Title         string `json:"title" yaml:"title"`                     // 中文标题
-	Description   string `json:"description" yaml:"description"`         // 描述
-	CreatorUserID int64  `json:"creator_user_id" yaml:"creator_user_id"` // 创建者ID
+	Title         string `json:"title" yaml:"title"`                       // 中文标题
+	Description   string `json:"description" yaml:"description"`           // 描述
+	CreatorUserID int64  `json:"creator_user_id" yaml:"creator_user_id"`   // 创建者ID
+	Version       int64  `json:"version" yaml:"version" gorm:"default:1;"` // 乐观锁版本号，每次更新角色时加 1
 
 	CreatedAt int64 `gorm:"autoCreateTime:milli"`
 	UpdatedAt int64 `gorm:"autoUpdateTime:milli"`
+	DeletedAt int64 `json:"deleted_at" yaml:"deleted_at" gorm:"uniqueIndex:idx_roles_name;default:0;"` // 软删除时间，为 0 代表未删除
 }
 
 // 角色拥有的权限组
@@ -60,3 +64,23 @@ type UserRole struct {
 
 	CreatedAt int64 `gorm:"autoCreateTime:milli"`
 }
+
+// 用户在某个对象下的角色集合版本，用户角色每次变化时加 1，用于 AssignRolesToUser 的并发控制
+type UserRolesVersion struct {
+	UserID       int64  `json:"user_id" yaml:"user_id" gorm:"primaryKey;autoIncrement:false;"`
+	RoleableType string `json:"roleable_type" yaml:"roleable_type" gorm:"primaryKey;autoIncrement:false;size:128;"`
+	RoleableID   int64  `json:"roleable_id" yaml:"roleable_id" gorm:"primaryKey;autoIncrement:false;"`
+	Version      int64  `json:"version" yaml:"version"`
+}
+
+// 权限、权限组和预置角色的多语言标题和描述，在 SyncPermissionMetadata 时根据元数据同步
+type PermissionTranslation struct {
+	Kind         string `json:"kind" yaml:"kind" gorm:"primaryKey;autoIncrement:false;size:32;"`                    // permission | permission_group | role
+	RoleableType string `json:"roleable_type" yaml:"roleable_type" gorm:"primaryKey;autoIncrement:false;size:128;"` // 只有预置角色使用
+	Name         string `json:"name" yaml:"name" gorm:"primaryKey;autoIncrement:false;size:256;"`
+	Locale       string `json:"locale" yaml:"locale" gorm:"primaryKey;autoIncrement:false;size:32;"` // 小写并使用 - 分隔，比如 en, ja-jp
+	Title        string `json:"title" yaml:"title"`
+	Description  string `json:"description" yaml:"description"`
+
+	CreatedAt int64 `gorm:"autoCreateTime:milli"`
+}
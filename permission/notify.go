@@ -0,0 +1,212 @@
+package permission
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 权限数据变更通知，用于多实例间失效缓存
+type PermissionChange struct {
+	ID           int64  `json:"id" yaml:"id" gorm:"primarykey"`
+	Type         string `json:"type" yaml:"type" gorm:"size:64;"`                    // 事件类型，同 Event.Type
+	RoleableType string `json:"roleable_type" yaml:"roleable_type" gorm:"size:128;"` // 元数据同步时为空
+	RoleableID   int64  `json:"roleable_id" yaml:"roleable_id"`
+	RoleID       int64  `json:"role_id" yaml:"role_id"` // 角色事件时不为 0
+	UserID       int64  `json:"user_id" yaml:"user_id"` // 用户角色变更时不为 0
+
+	CreatedAt int64 `json:"created_at" yaml:"created_at" gorm:"index;autoCreateTime:milli"`
+}
+
+// 变更通知的发布和订阅
+type ChangeNotifier interface {
+	Publish(ctx context.Context, change *PermissionChange) error           // 事务提交后调用
+	Subscribe(handler func(change *PermissionChange)) (unsubscribe func()) // handler 不应阻塞
+}
+
+// 实现该接口的 ChangeNotifier 在变更所在事务中发布，变更和通知一起提交或回滚
+type TxChangePublisher interface {
+	PublishTx(ctx context.Context, tx *gorm.DB, change *PermissionChange) error
+}
+
+// 将角色、用户角色和元数据变更发布到 notifier
+func WithChangeNotifier(notifier ChangeNotifier) PermissionServiceOption {
+	return func(s *PermissionService) {
+		s.notifier = notifier
+		if publisher, ok := notifier.(TxChangePublisher); ok {
+			s.txHooks = append(s.txHooks, HookFunc(func(ctx context.Context, event *Event) error {
+				return publisher.PublishTx(ctx, event.Tx, newPermissionChange(event))
+			}))
+			return
+		}
+		s.hooks = append(s.hooks, HookFunc(func(ctx context.Context, event *Event) error {
+			return notifier.Publish(ctx, newPermissionChange(event))
+		}))
+	}
+}
+
+// 订阅权限数据变更，未配置 ChangeNotifier 时不会收到通知
+func (s *PermissionService) SubscribeChanges(handler func(change *PermissionChange)) (unsubscribe func()) {
+	if s.notifier == nil {
+		return func() {}
+	}
+	return s.notifier.Subscribe(handler)
+}
+
+func newPermissionChange(event *Event) *PermissionChange {
+	change := &PermissionChange{Type: event.Type}
+	snapshot := event.After
+	if snapshot == nil {
+		snapshot = event.Before
+	}
+	switch v := snapshot.(type) {
+	case *RoleSnapshot:
+		change.RoleableType = v.Role.RoleableType
+		change.RoleableID = v.Role.RoleableID
+		change.RoleID = v.Role.ID
+	case *UserRolesSnapshot:
+		change.RoleableType = v.RoleableType
+		change.RoleableID = v.RoleableID
+		change.UserID = v.UserID
+	}
+	return change
+}
+
+type changeSubscribers struct {
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[int]func(change *PermissionChange)
+}
+
+func (c *changeSubscribers) Subscribe(handler func(change *PermissionChange)) (unsubscribe func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.handlers == nil {
+		c.handlers = make(map[int]func(change *PermissionChange))
+	}
+	id := c.nextID
+	c.nextID++
+	c.handlers[id] = handler
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.handlers, id)
+	}
+}
+
+func (c *changeSubscribers) notify(change *PermissionChange) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, handler := range c.handlers {
+		handler(change)
+	}
+}
+
+// 进程内变更通知，适用于单实例部署或测试
+type InProcessChangeNotifier struct {
+	changeSubscribers
+}
+
+func NewInProcessChangeNotifier() *InProcessChangeNotifier {
+	return &InProcessChangeNotifier{}
+}
+
+func (n *InProcessChangeNotifier) Publish(ctx context.Context, change *PermissionChange) error {
+	n.notify(change)
+	return nil
+}
+
+// 基于数据库变更表轮询的变更通知，变更在所在事务中写入变更表，各实例通过 Run 轮询获取
+//
+// 发布变更的实例同样通过轮询收到通知
+type PollingChangeNotifier struct {
+	changeSubscribers
+
+	db        *gorm.DB
+	interval  time.Duration // 轮询间隔
+	retention time.Duration // 变更保留时间，超过后由 Run 清理
+
+	pollMu      sync.Mutex
+	initialized bool
+	lastID      int64 // 已通知的最大变更ID
+}
+
+func NewPollingChangeNotifier(db *gorm.DB, interval, retention time.Duration) *PollingChangeNotifier {
+	return &PollingChangeNotifier{
+		db:        db,
+		interval:  interval,
+		retention: retention,
+	}
+}
+
+// 变更表结构迁移
+func (n *PollingChangeNotifier) Migrate() error {
+	return n.db.AutoMigrate(&PermissionChange{})
+}
+
+func (n *PollingChangeNotifier) Publish(ctx context.Context, change *PermissionChange) error {
+	return n.PublishTx(ctx, n.db.WithContext(ctx), change)
+}
+
+func (n *PollingChangeNotifier) PublishTx(ctx context.Context, tx *gorm.DB, change *PermissionChange) error {
+	return tx.Create(change).Error
+}
+
+// 通知变更表中新增的变更，返回通知的变更数，首次调用只记录当前位置不通知历史变更
+//
+// 按变更ID递增读取，并发事务中ID较小的变更晚于ID较大的变更提交时可能漏掉通知，缓存需同时设置过期时间兜底
+func (n *PollingChangeNotifier) Poll(ctx context.Context) (int, error) {
+	n.pollMu.Lock()
+	defer n.pollMu.Unlock()
+
+	db := n.db.WithContext(ctx)
+	if !n.initialized {
+		var lastID int64
+		if err := db.Model(&PermissionChange{}).Select("COALESCE(MAX(id), 0)").Scan(&lastID).Error; err != nil {
+			return 0, err
+		}
+		n.lastID = lastID
+		n.initialized = true
+		return 0, nil
+	}
+
+	var changes []*PermissionChange
+	if err := db.Where("id > ?", n.lastID).Order("id").Limit(1000).Find(&changes).Error; err != nil {
+		return 0, err
+	}
+	for _, change := range changes {
+		n.notify(change)
+		n.lastID = change.ID
+	}
+	return len(changes), nil
+}
+
+// 按 interval 轮询变更并清理过期变更，直到 ctx 结束
+func (n *PollingChangeNotifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := n.Poll(ctx); err != nil {
+			log.Println(fmt.Errorf("poll permission changes err=%w", err))
+		}
+		if n.retention > 0 {
+			if err := n.db.WithContext(ctx).
+				Where("created_at < ?", time.Now().Add(-n.retention).UnixMilli()).
+				Delete(&PermissionChange{}).Error; err != nil {
+				log.Println(fmt.Errorf("purge permission changes err=%w", err))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
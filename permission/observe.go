@@ -0,0 +1,273 @@
+package permission
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 操作结果
+const (
+	ObservationResultAllowed = "allowed" // 检查通过
+	ObservationResultDenied  = "denied"  // 检查未通过
+	ObservationResultOK      = "ok"      // 变更或查询成功
+	ObservationResultError   = "error"   // 操作返回错误
+)
+
+// 一次检查或变更操作的观测数据
+type Observation struct {
+	Operation string        `json:"operation" yaml:"operation"` // 操作名，为 PermissionService 的方法名，比如 HasPermission, AddUserRoles
+	Duration  time.Duration `json:"duration" yaml:"duration"`
+	Result    string        `json:"result" yaml:"result"`
+	Err       error         `json:"-" yaml:"-"`
+}
+
+// 检查和变更操作的观测者，在操作返回前同步调用，实现需要并发安全且尽快返回
+type Observer interface {
+	Observe(ctx context.Context, observation *Observation)
+}
+
+type ObserverFunc func(ctx context.Context, observation *Observation)
+
+func (f ObserverFunc) Observe(ctx context.Context, observation *Observation) {
+	f(ctx, observation)
+}
+
+// 注册检查和变更操作的观测者
+func WithObserver(observer Observer) PermissionServiceOption {
+	return func(s *PermissionService) {
+		s.observers = append(s.observers, observer)
+	}
+}
+
+// 在方法开头通过 defer 调用，记录变更或查询操作，err 为方法的命名返回值
+func (s *PermissionService) observe(ctx context.Context, operation string, start time.Time, err *error) {
+	if len(s.observers) == 0 {
+		return
+	}
+	s.notifyObservers(ctx, operation, start, ObservationResultOK, *err)
+}
+
+// 在方法开头通过 defer 调用，记录检查操作，allowed 和 err 为方法的命名返回值
+func (s *PermissionService) observeCheck(ctx context.Context, operation string, start time.Time, allowed *bool, err *error) {
+	if len(s.observers) == 0 {
+		return
+	}
+	result := ObservationResultDenied
+	if *allowed {
+		result = ObservationResultAllowed
+	}
+	s.notifyObservers(ctx, operation, start, result, *err)
+}
+
+func (s *PermissionService) notifyObservers(ctx context.Context, operation string, start time.Time, result string, err error) {
+	if err != nil {
+		result = ObservationResultError
+	}
+	observation := &Observation{
+		Operation: operation,
+		Duration:  time.Since(start),
+		Result:    result,
+		Err:       err,
+	}
+	for _, observer := range s.observers {
+		observer.Observe(ctx, observation)
+	}
+}
+
+// 错误类型，用于指标标签，只区分已知的错误，其他错误为 _OTHER
+func ObservationErrorType(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, target := range []error{
+		ErrInvalidArgument,
+		ErrPermissionGroupNotFound,
+		ErrEmptyPermissionGroups,
+		ErrRoleNotFound,
+		ErrRoleAlreadyExists,
+		ErrVersionConflict,
+		ErrMinHoldersViolated,
+		ErrRoleConstraintViolated,
+		ErrPrivilegeEscalation,
+		context.Canceled,
+		context.DeadlineExceeded,
+	} {
+		if errors.Is(err, target) {
+			return strings.ReplaceAll(target.Error(), " ", "_")
+		}
+	}
+	return "_OTHER"
+}
+
+// OpenTelemetry 属性，对应 attribute.String
+type OTelAttribute struct {
+	Key   string
+	Value string
+}
+
+// 对应 OpenTelemetry metric.Float64Histogram，使用 otel SDK 时包装 Record，将属性转换为 metric.WithAttributes
+type OTelFloat64Histogram interface {
+	Record(ctx context.Context, value float64, attrs ...OTelAttribute)
+}
+
+// OpenTelemetry 指标名称和属性
+const (
+	OTelMetricOperationDuration = "permission.operation.duration" // 直方图，单位为秒
+	OTelAttributeOperation      = "permission.operation"
+	OTelAttributeResult         = "permission.result"
+	OTelAttributeErrorType      = "error.type" // 只在出错时包含，值为 ObservationErrorType
+)
+
+// 按 OpenTelemetry 语义约定记录操作耗时，操作次数和拒绝率可由直方图的 count 按 permission.result 统计
+type OTelObserver struct {
+	duration OTelFloat64Histogram
+}
+
+// duration 为名称是 OTelMetricOperationDuration，单位为 s 的直方图
+func NewOTelObserver(duration OTelFloat64Histogram) *OTelObserver {
+	return &OTelObserver{duration: duration}
+}
+
+func (o *OTelObserver) Observe(ctx context.Context, observation *Observation) {
+	attrs := []OTelAttribute{
+		{Key: OTelAttributeOperation, Value: observation.Operation},
+		{Key: OTelAttributeResult, Value: observation.Result},
+	}
+	if observation.Err != nil {
+		attrs = append(attrs, OTelAttribute{Key: OTelAttributeErrorType, Value: ObservationErrorType(observation.Err)})
+	}
+	o.duration.Record(ctx, observation.Duration.Seconds(), attrs...)
+}
+
+// Prometheus 直方图默认的桶，单位为秒
+var DefaultPrometheusBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// 在内存中统计 Prometheus 风格的计数器和直方图，通过 WriteTo 或 ServeHTTP 以文本格式输出
+//
+//	permission_operations_total{operation, result}      操作次数
+//	permission_operation_duration_seconds{operation}   操作耗时
+type PrometheusObserver struct {
+	buckets []float64
+
+	mu         sync.Mutex
+	counters   map[prometheusCounterKey]uint64
+	histograms map[string]*prometheusHistogram
+}
+
+type prometheusCounterKey struct {
+	operation string
+	result    string
+}
+
+type prometheusHistogram struct {
+	counts []uint64 // 每个桶的计数，不累加
+	sum    float64
+	count  uint64
+}
+
+// buckets 为空时使用 DefaultPrometheusBuckets
+func NewPrometheusObserver(buckets ...float64) *PrometheusObserver {
+	if len(buckets) == 0 {
+		buckets = DefaultPrometheusBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &PrometheusObserver{
+		buckets:    slices.Compact(buckets),
+		counters:   make(map[prometheusCounterKey]uint64),
+		histograms: make(map[string]*prometheusHistogram),
+	}
+}
+
+func (o *PrometheusObserver) Observe(ctx context.Context, observation *Observation) {
+	seconds := observation.Duration.Seconds()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.counters[prometheusCounterKey{operation: observation.Operation, result: observation.Result}]++
+	h, ok := o.histograms[observation.Operation]
+	if !ok {
+		h = &prometheusHistogram{counts: make([]uint64, len(o.buckets))}
+		o.histograms[observation.Operation] = h
+	}
+	if i, _ := slices.BinarySearch(o.buckets, seconds); i < len(o.buckets) {
+		h.counts[i]++
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// 操作次数
+func (o *PrometheusObserver) Count(operation, result string) uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.counters[prometheusCounterKey{operation: operation, result: result}]
+}
+
+// 以 Prometheus 文本格式输出全部指标，按标签排序
+func (o *PrometheusObserver) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	o.mu.Lock()
+	counterKeys := make([]prometheusCounterKey, 0, len(o.counters))
+	for key := range o.counters {
+		counterKeys = append(counterKeys, key)
+	}
+	slices.SortFunc(counterKeys, func(a, b prometheusCounterKey) int {
+		if c := strings.Compare(a.operation, b.operation); c != 0 {
+			return c
+		}
+		return strings.Compare(a.result, b.result)
+	})
+	buf.WriteString("# HELP permission_operations_total Total number of permission operations.\n")
+	buf.WriteString("# TYPE permission_operations_total counter\n")
+	for _, key := range counterKeys {
+		fmt.Fprintf(&buf, "permission_operations_total{operation=%q,result=%q} %d\n", key.operation, key.result, o.counters[key])
+	}
+
+	operations := make([]string, 0, len(o.histograms))
+	for operation := range o.histograms {
+		operations = append(operations, operation)
+	}
+	slices.Sort(operations)
+	buf.WriteString("# HELP permission_operation_duration_seconds Duration of permission operations in seconds.\n")
+	buf.WriteString("# TYPE permission_operation_duration_seconds histogram\n")
+	for _, operation := range operations {
+		h := o.histograms[operation]
+		var cumulative uint64
+		for i, bucket := range o.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&buf, "permission_operation_duration_seconds_bucket{operation=%q,le=%q} %d\n", operation, formatPrometheusFloat(bucket), cumulative)
+		}
+		fmt.Fprintf(&buf, "permission_operation_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", operation, h.count)
+		fmt.Fprintf(&buf, "permission_operation_duration_seconds_sum{operation=%q} %s\n", operation, formatPrometheusFloat(h.sum))
+		fmt.Fprintf(&buf, "permission_operation_duration_seconds_count{operation=%q} %d\n", operation, h.count)
+	}
+	o.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// 以 Prometheus 文本格式输出全部指标，可以直接注册为 /metrics
+func (o *PrometheusObserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	o.WriteTo(w)
+}
+
+func formatPrometheusFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,248 @@
+package permission
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OpenAPI 路径下表示操作的字段
+var openAPIMethods = map[string]struct{}{
+	"get": {}, "put": {}, "post": {}, "delete": {}, "options": {}, "head": {}, "patch": {}, "trace": {},
+}
+
+type openAPIDocument struct {
+	OpenAPI string       `yaml:"openapi"`
+	Paths   yaml.Node    `yaml:"paths"`
+	Tags    []openAPITag `yaml:"tags"`
+}
+
+type openAPITag struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+}
+
+// OpenAPI 文档中的操作
+type OpenAPIOperation struct {
+	Method      string   `json:"method" yaml:"method"` // 大写的请求方法，比如 GET
+	Path        string   `json:"path" yaml:"path"`     // 路径模板，比如 /apps/{id}
+	OperationID string   `json:"operation_id" yaml:"operation_id"`
+	Summary     string   `json:"summary" yaml:"summary"`
+	Tags        []string `json:"tags" yaml:"tags"`
+}
+
+type ImportOpenAPIParam struct {
+	Domain         string                            `json:"domain" yaml:"domain"`                   // 生成权限和权限组的 domain
+	ResourcePrefix string                            `json:"resource_prefix" yaml:"resource_prefix"` // 资源路径前缀，比如 servers 中的 /api/v1
+	TagsAsGroups   bool                              `json:"tags_as_groups" yaml:"tags_as_groups"`   // 是否将 tag 生成为权限组
+	NameFunc       func(op *OpenAPIOperation) string `json:"-" yaml:"-"`                             // 自定义权限 name，为 nil 时使用 operationId，没有 operationId 时使用 RoutePermissionName
+}
+
+// 解析 OpenAPI 3 文档（YAML 或 JSON），每个路径下的操作生成一个权限，按文档中的顺序排列
+//
+// name 为 operationId，Title 为 summary，Resource 为路径模板，Action 为大写的请求方法；
+// TagsAsGroups 为 true 时每个 tag 生成一个包含其操作权限的顶层权限组，Title 为 tag 的 description，没有时为 tag name
+func ImportOpenAPI(r io.Reader, param ImportOpenAPIParam) (*PermissionMetadata, error) {
+	var doc openAPIDocument
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: decode openapi document: %v", ErrInvalidArgument, err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("%w: unsupported openapi version %q", ErrInvalidArgument, doc.OpenAPI)
+	}
+	operations, err := openAPIOperations(&doc.Paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	metadata := &PermissionMetadata{}
+	namesMap := make(map[string]*OpenAPIOperation, len(operations))
+	tagPermissionNamesMap := make(map[string][]string)
+	var tagNames []string
+	for _, op := range operations {
+		name := op.OperationID
+		if param.NameFunc != nil {
+			name = param.NameFunc(op)
+		} else if name == "" {
+			name = RoutePermissionName(&Route{Method: op.Method, Path: op.Path}, "")
+		}
+		if existed, ok := namesMap[name]; ok {
+			errs = append(errs, fmt.Errorf("operation %s %s and operation %s %s both named %s", existed.Method, existed.Path, op.Method, op.Path, name))
+			continue
+		}
+		namesMap[name] = op
+
+		title := op.Summary
+		if title == "" {
+			title = op.Method + " " + op.Path
+		}
+		metadata.Permissions = append(metadata.Permissions, &PermissionItem{
+			Name:     name,
+			Title:    title,
+			Domain:   param.Domain,
+			Resource: strings.TrimSuffix(param.ResourcePrefix, "/") + op.Path,
+			Action:   op.Method,
+		})
+		for _, tag := range op.Tags {
+			if _, ok := tagPermissionNamesMap[tag]; !ok {
+				tagNames = append(tagNames, tag)
+			}
+			tagPermissionNamesMap[tag] = append(tagPermissionNamesMap[tag], name)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if param.TagsAsGroups {
+		tagTitlesMap := make(map[string]string, len(doc.Tags))
+		for _, tag := range doc.Tags {
+			tagTitlesMap[tag.Name] = tag.Description
+		}
+		for _, tag := range tagNames {
+			title := tagTitlesMap[tag]
+			if title == "" {
+				title = tag
+			}
+			metadata.PermissionGroups = append(metadata.PermissionGroups, &PermissionGroupItem{
+				Name:        tag,
+				Domain:      param.Domain,
+				Title:       title,
+				Permissions: tagPermissionNamesMap[tag],
+			})
+		}
+	}
+	return metadata, nil
+}
+
+// 按文档顺序遍历 paths 下的操作，不支持 $ref 引用的路径
+func openAPIOperations(paths *yaml.Node) ([]*OpenAPIOperation, error) {
+	if paths.Kind == 0 {
+		return nil, nil
+	}
+	if paths.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%w: openapi paths is not an object", ErrInvalidArgument)
+	}
+
+	var operations []*OpenAPIOperation
+	for i := 0; i+1 < len(paths.Content); i += 2 {
+		path, item := paths.Content[i].Value, paths.Content[i+1]
+		if item.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%w: openapi path %s is not an object", ErrInvalidArgument, path)
+		}
+		for j := 0; j+1 < len(item.Content); j += 2 {
+			method := strings.ToLower(item.Content[j].Value)
+			if _, ok := openAPIMethods[method]; !ok {
+				continue
+			}
+			op := &OpenAPIOperation{Method: strings.ToUpper(method), Path: path}
+			if err := item.Content[j+1].Decode(&struct {
+				OperationID *string   `yaml:"operationId"`
+				Summary     *string   `yaml:"summary"`
+				Tags        *[]string `yaml:"tags"`
+			}{&op.OperationID, &op.Summary, &op.Tags}); err != nil {
+				return nil, fmt.Errorf("%w: openapi operation %s %s: %v", ErrInvalidArgument, op.Method, path, err)
+			}
+			operations = append(operations, op)
+		}
+	}
+	return operations, nil
+}
+
+// 将导入的权限元数据合并到手写的元数据中，返回新的元数据，不修改参数
+//
+// 手写的权限和权限组优先：name 相同或 domain, resource, action 相同的权限保留手写的内容，resource 中的 {id} 和 :id 视为相同，
+// 导入的权限组中的权限使用手写权限的 name；已有的权限组只追加本次新增的权限，
+// 不会加回手写时移除的权限，也不会修改标题和层级；新的权限组追加到顶层，角色和约束保持不变
+func MergePermissionMetadata(base, imported *PermissionMetadata) *PermissionMetadata {
+	merged := &PermissionMetadata{
+		Permissions:      append([]*PermissionItem(nil), base.Permissions...),
+		PermissionGroups: clonePermissionGroupItems(base.PermissionGroups),
+		Roles:            base.Roles,
+		RoleConstraints:  base.RoleConstraints,
+	}
+
+	key := func(p *PermissionItem) string {
+		return fmt.Sprintf("%s_%s_%s", p.Domain, normalizeResourcePath(p.Resource), p.Action)
+	}
+	baseNamesMap := make(map[string]struct{}, len(base.Permissions))
+	baseKeyNamesMap := make(map[string]string, len(base.Permissions))
+	for _, p := range base.Permissions {
+		baseNamesMap[p.Name] = struct{}{}
+		baseKeyNamesMap[key(p)] = p.Name
+	}
+	// 导入的权限 name 到合并后权限 name 的映射
+	mergedNamesMap := make(map[string]string, len(imported.Permissions))
+	addedNamesMap := make(map[string]struct{}, len(imported.Permissions))
+	for _, p := range imported.Permissions {
+		if _, ok := baseNamesMap[p.Name]; ok {
+			mergedNamesMap[p.Name] = p.Name
+			continue
+		}
+		if name, ok := baseKeyNamesMap[key(p)]; ok {
+			mergedNamesMap[p.Name] = name
+			continue
+		}
+		mergedNamesMap[p.Name] = p.Name
+		addedNamesMap[p.Name] = struct{}{}
+		merged.Permissions = append(merged.Permissions, p)
+	}
+
+	groupsMap := make(map[string]*PermissionGroupItem)
+	walkPermissionGroupTree(merged.PermissionGroups, func(item *PermissionGroupItem) {
+		groupsMap[item.Name] = item
+	})
+	var mergeGroups func(groups []*PermissionGroupItem)
+	mergeGroups = func(groups []*PermissionGroupItem) {
+		for _, g := range groups {
+			existed, ok := groupsMap[g.Name]
+			if !ok {
+				group := &PermissionGroupItem{Name: g.Name, Domain: g.Domain, Title: g.Title, Description: g.Description, Titles: g.Titles, Descriptions: g.Descriptions}
+				for _, name := range g.Permissions {
+					if mergedName, ok := mergedNamesMap[name]; ok {
+						name = mergedName
+					}
+					group.Permissions = appendMissing(group.Permissions, name)
+				}
+				groupsMap[group.Name] = group
+				merged.PermissionGroups = append(merged.PermissionGroups, group)
+			} else {
+				for _, name := range g.Permissions {
+					if _, ok := addedNamesMap[name]; ok {
+						existed.Permissions = appendMissing(existed.Permissions, name)
+					}
+				}
+			}
+			mergeGroups(g.PermissionGroups)
+		}
+	}
+	mergeGroups(imported.PermissionGroups)
+	return merged
+}
+
+func clonePermissionGroupItems(groups []*PermissionGroupItem) []*PermissionGroupItem {
+	if groups == nil {
+		return nil
+	}
+	cloned := make([]*PermissionGroupItem, 0, len(groups))
+	for _, g := range groups {
+		item := *g
+		item.Permissions = append([]string(nil), g.Permissions...)
+		item.PermissionGroups = clonePermissionGroupItems(g.PermissionGroups)
+		cloned = append(cloned, &item)
+	}
+	return cloned
+}
+
+func appendMissing(names []string, name string) []string {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
@@ -3,7 +3,12 @@ package permission
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -15,38 +20,55 @@ var migrationFs embed.FS
 type PermissionServiceOption func(*PermissionService)
 
 type PermissionItem struct {
-	Name     string `json:"name" yaml:"name"`
-	Title    string `json:"title" yaml:"title"`
-	Domain   string `json:"domain" yaml:"domain"`
-	Resource string `json:"resource" yaml:"resource"`
-	Action   string `json:"action" yaml:"action"`
+	Name         string            `json:"name" yaml:"name"`
+	Title        string            `json:"title" yaml:"title"`
+	Description  string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Domain       string            `json:"domain" yaml:"domain"`
+	Resource     string            `json:"resource" yaml:"resource"`
+	Action       string            `json:"action" yaml:"action"`
+	Titles       map[string]string `json:"titles,omitempty" yaml:"titles,omitempty"`             // 多语言标题，key 为 locale，比如 en, ja-JP
+	Descriptions map[string]string `json:"descriptions,omitempty" yaml:"descriptions,omitempty"` // 多语言描述，key 为 locale
 }
 
 type PermissionGroupItem struct {
 	Name             string                 `json:"name" yaml:"name"`
 	Domain           string                 `json:"domain,omitempty" yaml:"domain,omitempty"`
 	Title            string                 `json:"title" yaml:"title"`
+	Description      string                 `json:"description,omitempty" yaml:"description,omitempty"`
 	Permissions      []string               `json:"permissions,omitempty" yaml:"permissions,omitempty"`
 	PermissionGroups []*PermissionGroupItem `json:"permission_groups,omitempty" yaml:"permission_groups,omitempty"`
+	Granted          bool                   `json:"granted,omitempty" yaml:"granted,omitempty"`           // 只在用户权限树中使用，用户是否拥有该权限组
+	Titles           map[string]string      `json:"titles,omitempty" yaml:"titles,omitempty"`             // 多语言标题，key 为 locale
+	Descriptions     map[string]string      `json:"descriptions,omitempty" yaml:"descriptions,omitempty"` // 多语言描述，key 为 locale
 }
 
 type RolePermissionGroupItem struct {
-	RoleableType     string   `json:"roleable_type" yaml:"roleable_type"`
-	Name             string   `json:"name" yaml:"name"`
-	Title            string   `json:"title" yaml:"title"`
-	Description      string   `json:"description" yaml:"description"`
-	PermissionGroups []string `json:"permission_groups" yaml:"permission_groups"`
+	RoleableType     string            `json:"roleable_type" yaml:"roleable_type"`
+	Name             string            `json:"name" yaml:"name"`
+	Title            string            `json:"title" yaml:"title"`
+	Description      string            `json:"description" yaml:"description"`
+	PermissionGroups []string          `json:"permission_groups" yaml:"permission_groups"`
+	Titles           map[string]string `json:"titles,omitempty" yaml:"titles,omitempty"`             // 多语言标题，key 为 locale
+	Descriptions     map[string]string `json:"descriptions,omitempty" yaml:"descriptions,omitempty"` // 多语言描述，key 为 locale
 }
 
 type PermissionMetadata struct {
 	Permissions      []*PermissionItem          `json:"permissions" yaml:"permissions"`
 	PermissionGroups []*PermissionGroupItem     `json:"permission_groups" yaml:"permission_groups"`
 	Roles            []*RolePermissionGroupItem `json:"roles" yaml:"roles"`
+	RoleConstraints  []*RoleConstraintItem      `json:"role_constraints,omitempty" yaml:"role_constraints,omitempty"` // 职责分离约束
 }
 
 type PermissionService struct {
 	db       *gorm.DB
 	metadata *PermissionMetadata
+	hooks    []Hook // 事务提交后执行的 hook
+	txHooks  []Hook // 事务内执行的 hook
+	notifier ChangeNotifier
+
+	minHoldersRules []MinHoldersRule
+	fallbackLocales []string   // 多语言标题的回退 locale
+	observers       []Observer // 检查和变更操作的观测者
 
 	cachedTableNames struct {
 		permissionTableName                string
@@ -55,6 +77,7 @@ type PermissionService struct {
 		roleTableName                      string
 		rolePermissionGroupTableName       string
 		userRoleTableName                  string
+		userRolesVersionTableName          string
 	} // 缓存表名用于构造自定义查询语句，应对表名规则调整的情况
 }
 
@@ -79,16 +102,43 @@ func (s *PermissionService) cacheTableNames() {
 	s.cachedTableNames.roleTableName = s.db.Config.NamingStrategy.TableName("Role")
 	s.cachedTableNames.rolePermissionGroupTableName = s.db.Config.NamingStrategy.TableName("RolePermissionGroup")
 	s.cachedTableNames.userRoleTableName = s.db.Config.NamingStrategy.TableName("UserRole")
+	s.cachedTableNames.userRolesVersionTableName = s.db.Config.NamingStrategy.TableName("UserRolesVersion")
 }
 
 // 数据库表结构迁移
 func (s *PermissionService) Migrate() error {
-	return s.db.AutoMigrate(&Permission{}, &PermissionGroup{}, &PermissionGroupPermission{}, &Role{}, &RolePermissionGroup{}, &UserRole{})
+	if err := s.db.AutoMigrate(&Permission{}, &PermissionGroup{}, &PermissionGroupPermission{}, &Role{}, &RolePermissionGroup{}, &UserRole{}, &UserRolesVersion{}, &PermissionTranslation{}); err != nil {
+		return err
+	}
+	return s.migrateRoleNameIndex()
+}
+
+// 支持软删除前的 idx_roles_name 不包含 deleted_at，AutoMigrate 不会重建已存在的索引，需要删除后重新创建
+func (s *PermissionService) migrateRoleNameIndex() error {
+	migrator := s.db.Migrator()
+	indexes, err := migrator.GetIndexes(&Role{})
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index.Name() != "idx_roles_name" || slices.Contains(index.Columns(), "deleted_at") {
+			continue
+		}
+		if err := migrator.DropIndex(&Role{}, "idx_roles_name"); err != nil {
+			return err
+		}
+		return migrator.CreateIndex(&Role{}, "idx_roles_name")
+	}
+	return nil
 }
 
 // 输出数据库表结构迁移语句
 func (s *PermissionService) GetMigrateStatements() (string, error) {
-	dialectorName := s.db.Dialector.Name()
+	return GetMigrateStatements(s.db.Dialector.Name())
+}
+
+// 输出指定数据库的表结构迁移语句，支持 postgres | mysql | sqlite
+func GetMigrateStatements(dialectorName string) (string, error) {
 	migrationFileName := fmt.Sprintf("migrations/%s.sql", dialectorName)
 	switch dialectorName {
 	case "postgres", "mysql", "sqlite":
@@ -103,15 +153,40 @@ func (s *PermissionService) GetMigrateStatements() (string, error) {
 }
 
 // 同步权限元数据
-func (s *PermissionService) SyncPermissionMetadata(ctx context.Context) error {
-	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+func (s *PermissionService) SyncPermissionMetadata(ctx context.Context) (err error) {
+	defer s.observe(ctx, "SyncPermissionMetadata", time.Now(), &err)
+	return s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		// 元数据没有变化时不导出前后状态，也不发出 metadata.synced 事件
+		var changed bool
+		var before *PermissionMetadata
+		if s.hasHooks() {
+			var err error
+			if changed, err = s.metadataChanged(tx); err != nil {
+				return err
+			}
+			if changed {
+				if before, err = s.exportPermissionMetadata(tx, ExportPermissionMetadataParam{}); err != nil {
+					return err
+				}
+			}
+		}
 		if err := s.syncPermissions(tx); err != nil {
 			return err
 		}
 		if err := s.syncPermissionGroups(tx); err != nil {
 			return err
 		}
-		return nil
+		if err := s.syncTranslations(tx); err != nil {
+			return err
+		}
+		if !changed {
+			return nil
+		}
+		after, err := s.exportPermissionMetadata(tx, ExportPermissionMetadataParam{})
+		if err != nil {
+			return err
+		}
+		return emit(&Event{Type: EventMetadataSynced, Before: before, After: after})
 	})
 }
 
@@ -134,11 +209,12 @@ func (s *PermissionService) syncPermissions(tx *gorm.DB) error {
 			permissionKeysMap[permissionKey] = struct{}{}
 		}
 		permissions = append(permissions, &Permission{
-			Name:     p.Name,
-			Title:    p.Title,
-			Domain:   p.Domain,
-			Resource: p.Resource,
-			Action:   p.Action,
+			Name:        p.Name,
+			Title:       p.Title,
+			Description: p.Description,
+			Domain:      p.Domain,
+			Resource:    p.Resource,
+			Action:      p.Action,
 		})
 	}
 
@@ -163,7 +239,7 @@ func (s *PermissionService) syncPermissions(tx *gorm.DB) error {
 
 	if err := tx.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "name"}},
-		DoUpdates: clause.AssignmentColumns([]string{"title", "domain", "resource", "action"}),
+		DoUpdates: clause.AssignmentColumns([]string{"title", "description", "domain", "resource", "action"}),
 	}).Create(permissions).Error; err != nil {
 		return err
 	}
@@ -237,7 +313,7 @@ func (s *PermissionService) syncPermissionGroups(tx *gorm.DB) error {
 
 	if err := tx.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "name"}},
-		DoUpdates: clause.AssignmentColumns([]string{"title", "domain", "group_index", "parent_name"}),
+		DoUpdates: clause.AssignmentColumns([]string{"title", "description", "domain", "group_index", "parent_name"}),
 	}).Create(intermediateState.permissionGroups).Error; err != nil {
 		return err
 	}
@@ -253,11 +329,12 @@ func (s *PermissionService) syncPermissionGroups(tx *gorm.DB) error {
 
 func (s *PermissionService) createPermissionGroup(tx *gorm.DB, g *PermissionGroupItem, groupIndex int, parentName string, intermediateState *syncPermissionGroupIntermediateState) error {
 	permissionGroup := &PermissionGroup{
-		Name:       g.Name,
-		Domain:     g.Domain,
-		Title:      g.Title,
-		GroupIndex: groupIndex,
-		ParentName: parentName,
+		Name:        g.Name,
+		Domain:      g.Domain,
+		Title:       g.Title,
+		Description: g.Description,
+		GroupIndex:  groupIndex,
+		ParentName:  parentName,
 	}
 	intermediateState.permissionGroupKeys = append(intermediateState.permissionGroupKeys, g.Name)
 	intermediateState.permissionGroups = append(intermediateState.permissionGroups, permissionGroup)
@@ -308,12 +385,24 @@ func (s *PermissionService) createPermissionGroup(tx *gorm.DB, g *PermissionGrou
 }
 
 // 同步某个应用下的预置角色
-func (s *PermissionService) SyncPresetRoles(tx *gorm.DB, roleableID int64, roleableType string) error {
+func (s *PermissionService) SyncPresetRoles(tx *gorm.DB, roleableID int64, roleableType string) (err error) {
+	defer s.observe(tx.Statement.Context, "SyncPresetRoles", time.Now(), &err)
+	emit := func(event *Event) error {
+		return s.runTxHooks(tx.Statement.Context, tx, event)
+	}
 	for _, roleGroups := range s.metadata.Roles {
 		if roleGroups.RoleableType != roleableType {
 			continue
 		}
 
+		var before *RoleSnapshot
+		if s.hasHooks() {
+			var err error
+			if before, err = s.roleSnapshotByName(tx, roleableType, roleableID, roleGroups.Name); err != nil {
+				return err
+			}
+		}
+
 		role := &Role{
 			RoleableType: roleableType,
 			RoleableID:   roleableID,
@@ -321,7 +410,7 @@ func (s *PermissionService) SyncPresetRoles(tx *gorm.DB, roleableID int64, rolea
 			Title:        roleGroups.Title,
 			Description:  roleGroups.Description,
 		}
-		if err := tx.FirstOrCreate(role, &Role{
+		if err := tx.Scopes(notDeletedRole).FirstOrCreate(role, &Role{
 			RoleableType: roleableType,
 			RoleableID:   roleableID,
 			Name:         roleGroups.Name,
@@ -343,6 +432,9 @@ func (s *PermissionService) SyncPresetRoles(tx *gorm.DB, roleableID int64, rolea
 				return err
 			}
 		}
+		if err := s.emitRoleSaved(tx, emit, before, role.ID); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -357,8 +449,14 @@ type CreateRoleParam struct {
 	CreatorUserID    int64    `json:"creator_user_id" yaml:"creator_user_id"`
 }
 
-// 创建角色
-func (s *PermissionService) CreateRole(ctx context.Context, param CreateRoleParam) (*Role, error) {
+// 创建角色，对象下已存在同名角色时返回 ErrRoleAlreadyExists，修改已有角色使用 UpdateRole
+func (s *PermissionService) CreateRole(ctx context.Context, param CreateRoleParam) (_ *Role, err error) {
+	defer s.observe(ctx, "CreateRole", time.Now(), &err)
+	return s.createRole(ctx, param, nil)
+}
+
+// check 不为 nil 时在创建角色的事务中先执行，返回错误时不创建
+func (s *PermissionService) createRole(ctx context.Context, param CreateRoleParam, check func(tx *gorm.DB) error) (*Role, error) {
 	role := Role{
 		Name:          param.Name,
 		RoleableType:  param.RoleableType,
@@ -368,18 +466,31 @@ func (s *PermissionService) CreateRole(ctx context.Context, param CreateRolePara
 		CreatorUserID: param.CreatorUserID,
 	}
 
-	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.FirstOrCreate(&role, Role{
-			RoleableType: param.RoleableType,
-			RoleableID:   param.RoleableID,
-			Name:         param.Name,
-		}).Error; err != nil {
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		if check != nil {
+			if err := check(tx); err != nil {
+				return err
+			}
+		}
+		var count int64
+		if err := tx.Model(&Role{}).
+			Scopes(notDeletedRole).
+			Where("roleable_type = ?", role.RoleableType).
+			Where("roleable_id = ?", role.RoleableID).
+			Where("name = ?", role.Name).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: role name %s existed in %s:%d", ErrRoleAlreadyExists, role.Name, role.RoleableType, role.RoleableID)
+		}
+		if err := tx.Create(&role).Error; err != nil {
 			return err
 		}
 		if err := s.assignPermissionGroupsToRole(tx, role.ID, param.PermissionGroups); err != nil {
 			return err
 		}
-		return nil
+		return s.emitRoleSaved(tx, emit, nil, role.ID)
 	}); err != nil {
 		return nil, err
 	}
@@ -389,51 +500,142 @@ func (s *PermissionService) CreateRole(ctx context.Context, param CreateRolePara
 
 type UpdateRoleParam struct {
 	ID               int64    `json:"id" yaml:"id"`
+	Version          int64    `json:"version" yaml:"version"` // 读取角色时的版本，不为 0 时角色版本不一致返回 VersionConflictError
 	Title            string   `json:"title" yaml:"title"`
 	Description      string   `json:"description" yaml:"description"`
 	PermissionGroups []string `json:"permission_groups" yaml:"permission_groups"`
 }
 
 // 更新角色
-func (s *PermissionService) UpdateRole(ctx context.Context, param UpdateRoleParam) (*Role, error) {
-	var role Role
-	if err := s.db.WithContext(ctx).Model(&Role{}).Where("id = ?", param.ID).First(&role).Error; err != nil {
+func (s *PermissionService) UpdateRole(ctx context.Context, param UpdateRoleParam) (_ *Role, err error) {
+	defer s.observe(ctx, "UpdateRole", time.Now(), &err)
+	return s.updateRole(ctx, param, nil)
+}
+
+// check 不为 nil 时在更新角色的事务中先执行，返回错误时不更新
+func (s *PermissionService) updateRole(ctx context.Context, param UpdateRoleParam, check func(tx *gorm.DB, role *Role) error) (*Role, error) {
+	role, err := s.GetRole(ctx, param.ID)
+	if err != nil {
 		return nil, err
 	}
+	if param.Version != 0 && param.Version != role.Version {
+		return nil, roleVersionConflict(role.ID, param.Version, role.Version)
+	}
 
-	role.Title = param.Title
-	role.Description = param.Description
-	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Save(&role).Error; err != nil {
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		if check != nil {
+			if err := check(tx, role); err != nil {
+				return err
+			}
+		}
+		var before *RoleSnapshot
+		if s.hasHooks() {
+			var err error
+			if before, err = s.roleSnapshot(tx, role.ID); err != nil {
+				return err
+			}
+		}
+		guard, err := s.newMinHoldersGuard(tx, role.RoleableType, role.RoleableID)
+		if err != nil {
 			return err
 		}
+		// 以读取时的版本作为条件更新，避免并发更新互相覆盖
+		result := tx.Model(&Role{}).
+			Scopes(notDeletedRole).
+			Where("id = ?", role.ID).
+			Where("version = ?", role.Version).
+			Updates(map[string]any{
+				"title":       param.Title,
+				"description": param.Description,
+				"version":     gorm.Expr("version + 1"),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var actualVersion int64
+			if err := tx.Model(&Role{}).Where("id = ?", role.ID).Pluck("version", &actualVersion).Error; err != nil {
+				return err
+			}
+			return roleVersionConflict(role.ID, role.Version, actualVersion)
+		}
 		if err := s.assignPermissionGroupsToRole(tx, role.ID, param.PermissionGroups); err != nil {
 			return err
 		}
-		return nil
+		if err := guard.check(tx); err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		return s.emitRoleSaved(tx, emit, before, role.ID)
 	}); err != nil {
 		return nil, err
 	}
-	return &role, nil
+	return s.GetRole(ctx, role.ID)
+}
+
+func roleVersionConflict(roleID, expectedVersion, actualVersion int64) error {
+	return &VersionConflictError{
+		Resource:        fmt.Sprintf("role:%d", roleID),
+		ExpectedVersion: strconv.FormatInt(expectedVersion, 10),
+		ActualVersion:   strconv.FormatInt(actualVersion, 10),
+	}
+}
+
+// 删除角色，软删除后角色不再参与权限检查和角色列表，但保留角色权限组和用户角色，可通过 RestoreRole 恢复
+func (s *PermissionService) DeleteRole(ctx context.Context, roleID int64) (err error) {
+	defer s.observe(ctx, "DeleteRole", time.Now(), &err)
+	return s.deleteRole(ctx, roleID, nil)
 }
 
-// 删除角色
-func (s *PermissionService) DeleteRole(ctx context.Context, roleID int64) error {
-	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Where("role_id = ?", roleID).Delete(&RolePermissionGroup{}).Error; err != nil {
+// check 在删除前检查，为 nil 时不检查
+func (s *PermissionService) deleteRole(ctx context.Context, roleID int64, check func(tx *gorm.DB, role *Role) error) error {
+	return s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		var role Role
+		if err := tx.Scopes(notDeletedRole).Where("id = ?", roleID).Limit(1).Find(&role).Error; err != nil {
+			return err
+		}
+		if role.ID == 0 {
+			return fmt.Errorf("%w: role id %d", ErrRoleNotFound, roleID)
+		}
+		if check != nil {
+			if err := check(tx, &role); err != nil {
+				return err
+			}
+		}
+		var before *RoleSnapshot
+		if s.hasHooks() {
+			var err error
+			if before, err = s.roleSnapshot(tx, roleID); err != nil {
+				return err
+			}
+		}
+		guard, err := s.newMinHoldersGuard(tx, role.RoleableType, role.RoleableID)
+		if err != nil {
 			return err
 		}
-		if err := tx.Where("role_id = ?", roleID).Delete(&UserRole{}).Error; err != nil {
+		result := tx.Model(&Role{}).
+			Scopes(notDeletedRole).
+			Where("id = ?", roleID).
+			Update("deleted_at", time.Now().UnixMilli())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: role id %d", ErrRoleNotFound, roleID)
+		}
+		if err := s.bumpRoleUserRolesVersions(tx, &role); err != nil {
 			return err
 		}
-		if err := tx.Where("id = ?", roleID).Delete(&Role{}).Error; err != nil {
+		if err := guard.check(tx); err != nil {
 			return err
 		}
-		return nil
-	}); err != nil {
-		return err
-	}
-	return nil
+		if before == nil {
+			return nil
+		}
+		return emit(&Event{Type: EventRoleDeleted, Before: before})
+	})
 }
 
 // 为角色分配权限组
@@ -443,8 +645,18 @@ func (s *PermissionService) assignPermissionGroupsToRole(tx *gorm.DB, roleID int
 		return err
 	}
 
-	if len(permissionGroups) != len(permissionGroupNames) {
-		return fmt.Errorf("some permission groups not found")
+	existedNamesMap := make(map[string]struct{}, len(permissionGroups))
+	for _, g := range permissionGroups {
+		existedNamesMap[g.Name] = struct{}{}
+	}
+	var missing []string
+	for _, name := range permissionGroupNames {
+		if _, ok := existedNamesMap[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrPermissionGroupNotFound, strings.Join(missing, ", "))
 	}
 
 	rolePermissionGroups := make([]*RolePermissionGroup, 0, len(permissionGroups))
@@ -455,7 +667,7 @@ func (s *PermissionService) assignPermissionGroupsToRole(tx *gorm.DB, roleID int
 		})
 	}
 	if len(permissionGroupNames) == 0 {
-		return fmt.Errorf("role must have at least one permission groups")
+		return ErrEmptyPermissionGroups
 	}
 
 	if err := tx.Where("role_id = ?", roleID).Delete(&RolePermissionGroup{}).Error; err != nil {
@@ -475,12 +687,20 @@ type AssignRolesToUserParam struct {
 	RoleableType string  `json:"roleable_type" yaml:"roleable_type"`
 	RoleableID   int64   `json:"roleable_id" yaml:"roleable_id"`
 	RoleIDs      []int64 `json:"role_ids" yaml:"role_ids"`
+	Version      string  `json:"version" yaml:"version"` // GetUserRolesVersion 返回的版本，不为空时用户角色已变化返回 VersionConflictError
 }
 
 // 为用户分配角色
-func (s *PermissionService) AssignRolesToUser(ctx context.Context, param AssignRolesToUserParam) error {
+func (s *PermissionService) AssignRolesToUser(ctx context.Context, param AssignRolesToUserParam) (err error) {
+	defer s.observe(ctx, "AssignRolesToUser", time.Now(), &err)
+	return s.assignRolesToUser(ctx, param, nil)
+}
+
+// check 不为 nil 时在分配角色的事务中先执行，返回错误时不分配
+func (s *PermissionService) assignRolesToUser(ctx context.Context, param AssignRolesToUserParam, check func(tx *gorm.DB) error) error {
 	var roles []*Role
-	if err := s.db.WithContext(ctx).Where("roleable_type = ?", param.RoleableType).
+	if err := s.db.WithContext(ctx).Scopes(notDeletedRole).
+		Where("roleable_type = ?", param.RoleableType).
 		Where("roleable_id = ?", param.RoleableID).
 		Find(&roles).Error; err != nil {
 		return err
@@ -495,7 +715,7 @@ func (s *PermissionService) AssignRolesToUser(ctx context.Context, param AssignR
 	userRoles := make([]*UserRole, 0, len(param.RoleIDs))
 	for _, roleID := range param.RoleIDs {
 		if _, ok := rolesMap[roleID]; !ok {
-			return fmt.Errorf("role id %d not found in %s:%d", roleID, param.RoleableType, param.RoleableID)
+			return fmt.Errorf("%w: role id %d not found in %s:%d", ErrRoleNotFound, roleID, param.RoleableType, param.RoleableID)
 		}
 		userRoles = append(userRoles, &UserRole{
 			UserID: param.UserID,
@@ -503,21 +723,56 @@ func (s *PermissionService) AssignRolesToUser(ctx context.Context, param AssignR
 		})
 	}
 
-	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		if check != nil {
+			if err := check(tx); err != nil {
+				return err
+			}
+		}
+		var before *UserRolesSnapshot
+		if s.hasHooks() {
+			var err error
+			if before, err = s.userRolesSnapshot(tx, param.UserID, param.RoleableID, param.RoleableType); err != nil {
+				return err
+			}
+		}
+		guard, err := s.newMinHoldersGuard(tx, param.RoleableType, param.RoleableID)
+		if err != nil {
+			return err
+		}
+		// 先带版本条件更新版本行，并发的分配会在这里按行锁排队，只有一个能通过
+		if param.Version != "" {
+			if err := s.compareAndBumpUserRolesVersion(tx, param.UserID, param.RoleableType, param.RoleableID, param.Version); err != nil {
+				return err
+			}
+		} else if err := s.bumpUserRolesVersions(tx, param.RoleableType, param.RoleableID, param.UserID); err != nil {
+			return err
+		}
 		if len(roleIDs) > 0 {
 			if err := tx.Where("user_id = ?", param.UserID).
-				Where("role_id IN (?)", tx.Model(&Role{}).Select("id").Where("roleable_type = ?", param.RoleableType).Where("roleable_id = ?", param.RoleableID)).
+				Where("role_id IN (?)", tx.Model(&Role{}).Scopes(notDeletedRole).Select("id").Where("roleable_type = ?", param.RoleableType).Where("roleable_id = ?", param.RoleableID)).
 				Delete(&UserRole{}).Error; err != nil {
 				return err
 			}
 		}
-		if err := tx.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "user_id"}, {Name: "role_id"}},
-			DoNothing: true,
-		}).Create(userRoles).Error; err != nil {
+		if len(userRoles) > 0 {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}, {Name: "role_id"}},
+				DoNothing: true,
+			}).Create(userRoles).Error; err != nil {
+				return err
+			}
+		}
+		if err := guard.check(tx); err != nil {
 			return err
 		}
-		return nil
+		if err := s.checkRoleConstraints(tx, param.UserID, param.RoleableID, param.RoleableType); err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		return s.emitUserRolesChanged(tx, emit, before)
 	}); err != nil {
 		return err
 	}
@@ -534,11 +789,12 @@ type HasPermissionParam struct {
 }
 
 // 检查用户是否有特定权限
-func (s *PermissionService) HasPermission(ctx context.Context, param HasPermissionParam) (bool, error) {
+func (s *PermissionService) HasPermission(ctx context.Context, param HasPermissionParam) (allowed bool, err error) {
+	defer s.observeCheck(ctx, "HasPermission", time.Now(), &allowed, &err)
 	sql := fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE name IN (
 		SELECT permission_name FROM %s WHERE permission_group_name IN (
 			SELECT permission_group_name FROM %s WHERE role_id IN (
-				SELECT id FROM %s WHERE roleable_type = ? AND roleable_id = ? AND id IN (
+				SELECT id FROM %s WHERE roleable_type = ? AND roleable_id = ? AND deleted_at = 0 AND id IN (
 					SELECT role_id FROM %s WHERE user_id = ?
 				)
 			)
@@ -565,9 +821,10 @@ type HasPermissionGroupParam struct {
 }
 
 // 检查用户在某个对象下是否拥有某个权限组
-func (s *PermissionService) HasPermissionGroup(ctx context.Context, param HasPermissionGroupParam) (bool, error) {
+func (s *PermissionService) HasPermissionGroup(ctx context.Context, param HasPermissionGroupParam) (allowed bool, err error) {
+	defer s.observeCheck(ctx, "HasPermissionGroup", time.Now(), &allowed, &err)
 	sql := fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE role_id IN (
-		SELECT id FROM %s WHERE roleable_type = ? AND roleable_id = ? AND id IN (
+		SELECT id FROM %s WHERE roleable_type = ? AND roleable_id = ? AND deleted_at = 0 AND id IN (
 			SELECT role_id FROM %s WHERE user_id = ?
 		)
 	) AND permission_group_name = ?`,
@@ -590,13 +847,32 @@ type HasPermissionGroupsParam struct {
 }
 
 // 检查用户在某个对象下权限组列表拥有情况
-func (s *PermissionService) HasPermissionGroups(ctx context.Context, param HasPermissionGroupsParam) (map[string]bool, error) {
+//
+// 观测结果在拥有全部请求的权限组时为 allowed，否则为 denied
+func (s *PermissionService) HasPermissionGroups(ctx context.Context, param HasPermissionGroupsParam) (_ map[string]bool, err error) {
+	var allowed bool
+	defer s.observeCheck(ctx, "HasPermissionGroups", time.Now(), &allowed, &err)
+	granted, err := s.hasPermissionGroups(s.db.WithContext(ctx), param)
+	if err != nil {
+		return nil, err
+	}
+	allowed = len(granted) > 0
+	for _, ok := range granted {
+		if !ok {
+			allowed = false
+			break
+		}
+	}
+	return granted, nil
+}
+
+func (s *PermissionService) hasPermissionGroups(db *gorm.DB, param HasPermissionGroupsParam) (map[string]bool, error) {
 	if len(param.PermissionGroupNames) == 0 {
 		return nil, nil
 	}
 
 	sql := fmt.Sprintf(`SELECT DISTINCT permission_group_name FROM %s WHERE role_id IN (
-		SELECT id FROM %s WHERE roleable_type = ? AND roleable_id = ? AND id IN (
+		SELECT id FROM %s WHERE roleable_type = ? AND roleable_id = ? AND deleted_at = 0 AND id IN (
 			SELECT role_id FROM %s WHERE user_id = ?
 		)
 	) AND permission_group_name IN ?`,
@@ -605,7 +881,7 @@ func (s *PermissionService) HasPermissionGroups(ctx context.Context, param HasPe
 		s.cachedTableNames.userRoleTableName)
 
 	var existedPermissionGroupKeys []string
-	if err := s.db.WithContext(ctx).Raw(sql, param.RoleableType, param.RoleableID, param.UserID, param.PermissionGroupNames).Scan(&existedPermissionGroupKeys).Error; err != nil {
+	if err := db.Raw(sql, param.RoleableType, param.RoleableID, param.UserID, param.PermissionGroupNames).Scan(&existedPermissionGroupKeys).Error; err != nil {
 		return nil, err
 	}
 	existedPermissionGroupKeysMap := make(map[string]struct{}, len(existedPermissionGroupKeys))
@@ -621,26 +897,43 @@ func (s *PermissionService) HasPermissionGroups(ctx context.Context, param HasPe
 }
 
 // 应用下是否有任意角色
-func (s *PermissionService) HasAnyRole(ctx context.Context, userID, roleableID int64, roleableType string) (bool, error) {
+func (s *PermissionService) HasAnyRole(ctx context.Context, userID, roleableID int64, roleableType string) (allowed bool, err error) {
+	defer s.observeCheck(ctx, "HasAnyRole", time.Now(), &allowed, &err)
 	var count int64
 	if err := s.db.WithContext(ctx).Model(&UserRole{}).
 		Where("user_id = ?", userID).
-		Where("role_id IN (?)", s.db.Model(&Role{}).Select("id").Where("roleable_type = ?", roleableType).Where("roleable_id = ?", roleableID)).
+		Where("role_id IN (?)", s.db.Model(&Role{}).Scopes(notDeletedRole).Select("id").Where("roleable_type = ?", roleableType).Where("roleable_id = ?", roleableID)).
 		Count(&count).Error; err != nil {
 		return false, err
 	}
 	return count > 0, nil
 }
 
-// 获取角色列表
-func (s *PermissionService) GetRoles(ctx context.Context, roleableID int64, roleableType string) ([]*Role, error) {
+// 获取角色
+func (s *PermissionService) GetRole(ctx context.Context, roleID int64) (*Role, error) {
+	var role Role
+	if err := s.db.WithContext(ctx).Model(&Role{}).Scopes(notDeletedRole).Where("id = ?", roleID).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: role id %d", ErrRoleNotFound, roleID)
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+// 获取角色列表，locale 可选，指定预置角色标题和描述的语言，回退规则见 WithFallbackLocales
+func (s *PermissionService) GetRoles(ctx context.Context, roleableID int64, roleableType string, locale ...string) ([]*Role, error) {
 	var roles []*Role
 	if err := s.db.WithContext(ctx).Model(&Role{}).
+		Scopes(notDeletedRole).
 		Where("roleable_type = ?", roleableType).
 		Where("roleable_id = ?", roleableID).
 		Order("id").Find(&roles).Error; err != nil {
 		return nil, err
 	}
+	if err := s.LocalizeRoles(ctx, roles, optionalLocale(locale)); err != nil {
+		return nil, err
+	}
 	return roles, nil
 }
 
@@ -668,23 +961,64 @@ func (s *PermissionService) GetRolePermissionGroups(ctx context.Context, roleID
 	return permissionGroups, nil
 }
 
-// 获取角色列表
-func (s *PermissionService) GetUserRoles(ctx context.Context, userID, roleableID int64, roleableType string) ([]*Role, error) {
+// 获取用户角色列表，locale 可选，和 GetRoles 相同
+func (s *PermissionService) GetUserRoles(ctx context.Context, userID, roleableID int64, roleableType string, locale ...string) ([]*Role, error) {
 	var roles []*Role
 	if err := s.db.WithContext(ctx).Model(&Role{}).
+		Scopes(notDeletedRole).
 		Where("roleable_type = ?", roleableType).
 		Where("roleable_id = ?", roleableID).
 		Where("id IN (?)", s.db.Model(&UserRole{}).Select("role_id").Where("user_id = ?", userID)).
 		Order("id").Find(&roles).Error; err != nil {
 		return nil, err
 	}
+	if err := s.LocalizeRoles(ctx, roles, optionalLocale(locale)); err != nil {
+		return nil, err
+	}
 	return roles, nil
 }
 
+// 获取用户在某个对象下的角色集合版本，用于 AssignRolesToUser 的并发控制
+//
+// 用户角色每次变化时版本加 1，角色集合改回原样时版本也不同，用户从未有过角色时为 0
+func (s *PermissionService) GetUserRolesVersion(ctx context.Context, userID, roleableID int64, roleableType string) (string, error) {
+	return s.getUserRolesVersion(s.db.WithContext(ctx), userID, roleableID, roleableType)
+}
+
+func (s *PermissionService) getUserRolesVersion(tx *gorm.DB, userID, roleableID int64, roleableType string) (string, error) {
+	var versions []int64
+	if err := tx.Model(&UserRolesVersion{}).
+		Where("user_id = ?", userID).
+		Where("roleable_type = ?", roleableType).
+		Where("roleable_id = ?", roleableID).
+		Limit(1).Pluck("version", &versions).Error; err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "0", nil
+	}
+	return strconv.FormatInt(versions[0], 10), nil
+}
+
+// 获取用户在某个对象下的角色ID列表，按ID排序
+func (s *PermissionService) getUserRoleIDs(tx *gorm.DB, userID, roleableID int64, roleableType string) ([]int64, error) {
+	var roleIDs []int64
+	if err := tx.Model(&Role{}).
+		Scopes(notDeletedRole).
+		Where("roleable_type = ?", roleableType).
+		Where("roleable_id = ?", roleableID).
+		Where("id IN (?)", tx.Model(&UserRole{}).Select("role_id").Where("user_id = ?", userID)).
+		Order("id").Pluck("id", &roleIDs).Error; err != nil {
+		return nil, err
+	}
+	return roleIDs, nil
+}
+
 // 获取用户应用ID列表
 func (s *PermissionService) GetUserRoleableIDs(ctx context.Context, userID int64, roleableType string) ([]int64, error) {
 	var roleableIDs []int64
 	if err := s.db.WithContext(ctx).Model(&Role{}).
+		Scopes(notDeletedRole).
 		Distinct("roleable_id").
 		Where("roleable_type = ?", roleableType).
 		Where("id IN (?)", s.db.Model(&UserRole{}).Select("role_id").Where("user_id = ?", userID)).
@@ -702,6 +1036,7 @@ func (s *PermissionService) GetUserRoleableIDsMap(ctx context.Context, userID in
 
 	var roles []*Role
 	if err := s.db.WithContext(ctx).Model(&Role{}).
+		Scopes(notDeletedRole).
 		Where("roleable_type IN ?", roleableTypes).
 		Where("id IN (?)", s.db.Model(&UserRole{}).Select("role_id").Where("user_id = ?", userID)).
 		Find(&roles).Error; err != nil {
@@ -722,34 +1057,3 @@ func (s *PermissionService) GetUserRoleableIDsMap(ctx context.Context, userID in
 	}
 	return roleableIDsMap, nil
 }
-
-// 根据某个 domain 下所有权限组构造完整的权限树
-func (s *PermissionService) BuildFullPermissionGroupTree(ctx context.Context, domain string) ([]*PermissionGroupItem, error) {
-	var permissionGroups []*PermissionGroup
-	if err := s.db.WithContext(ctx).Model(&PermissionGroup{}).
-		Where("domain = ?", domain).
-		Order("group_index").
-		Find(&permissionGroups).Error; err != nil {
-		return nil, err
-	}
-
-	tree := s.BuildPermissionGroupTree(permissionGroups, "")
-	return tree, nil
-}
-
-// 根据权限组构造权限树
-func (s *PermissionService) BuildPermissionGroupTree(permissionGroups []*PermissionGroup, parentName string) []*PermissionGroupItem {
-	var tree []*PermissionGroupItem
-	for _, group := range permissionGroups {
-		item := &PermissionGroupItem{
-			Name:   group.Name,
-			Domain: group.Domain,
-			Title:  group.Title,
-		}
-		if group.ParentName == parentName {
-			item.PermissionGroups = s.BuildPermissionGroupTree(permissionGroups, group.Name)
-			tree = append(tree, item)
-		}
-	}
-	return tree
-}
@@ -1,9 +1,19 @@
 package permission
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v3"
 	"gorm.io/driver/sqlite"
@@ -84,3 +94,2360 @@ func TestPermissionService_HasPermission(t *testing.T) {
 		})
 	}
 }
+
+func TestPermissionService_ExportPermissionMetadata(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1001)
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := _permissionSvc.ExportPermissionMetadata(ctx, ExportPermissionMetadataParam{
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.Permissions) != len(_permissionSvc.metadata.Permissions) {
+		t.Errorf("len(Permissions) = %d, want %d", len(exported.Permissions), len(_permissionSvc.metadata.Permissions))
+	}
+	if len(exported.Roles) != len(_permissionSvc.metadata.Roles) {
+		t.Errorf("len(Roles) = %d, want %d", len(exported.Roles), len(_permissionSvc.metadata.Roles))
+	}
+
+	for _, format := range []string{MetadataFormatYAML, MetadataFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodePermissionMetadata(&buf, exported, format); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodePermissionMetadata(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "export.db")), &gorm.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc := New(db, decoded)
+			if err := svc.Migrate(); err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.SyncPermissionMetadata(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.SyncPresetRoles(db, roleableID, roleableType); err != nil {
+				t.Fatal(err)
+			}
+			reexported, err := svc.ExportPermissionMetadata(ctx, ExportPermissionMetadataParam{
+				RoleableType: roleableType,
+				RoleableID:   roleableID,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported, reexported) {
+				t.Errorf("ExportPermissionMetadata() round trip mismatch")
+			}
+		})
+	}
+}
+
+func TestPermissionMetadata_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata PermissionMetadata
+		wantErr  bool
+	}{
+		{
+			name:     "example metadata",
+			metadata: *_permissionSvc.metadata,
+			wantErr:  false,
+		},
+		{
+			name: "reduplicated permission name",
+			metadata: PermissionMetadata{
+				Permissions: []*PermissionItem{
+					{Name: "apps-get", Resource: "/api/v1/apps/:id", Action: "GET"},
+					{Name: "apps-get", Resource: "/api/v1/apps/:id", Action: "PUT"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "permission group with not existed permission",
+			metadata: PermissionMetadata{
+				PermissionGroups: []*PermissionGroupItem{
+					{Name: "app-manage", Permissions: []string{"apps-get"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "role with not existed permission group",
+			metadata: PermissionMetadata{
+				Roles: []*RolePermissionGroupItem{
+					{RoleableType: "app", Name: "admin", PermissionGroups: []string{"app-manage"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "role constraint with single exclusive role",
+			metadata: PermissionMetadata{
+				RoleConstraints: []*RoleConstraintItem{
+					{RoleableType: "app", Name: "publish-review", ExclusiveRoles: []string{"publisher"}},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.metadata.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("PermissionMetadata.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPermissionService_PlanSyncPermissionMetadata(t *testing.T) {
+	ctx := context.Background()
+	plan, err := _permissionSvc.PlanSyncPermissionMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.IsEmpty() {
+		t.Errorf("PermissionService.PlanSyncPermissionMetadata() = %+v, want empty plan", plan)
+	}
+
+	metadata := *_permissionSvc.metadata
+	metadata.Permissions = append([]*PermissionItem{{Name: "apps-meta-events-get", Resource: "/api/v1/apps/:id/meta/events", Action: "GET"}}, metadata.Permissions[1:]...)
+	plan, err = New(_permissionSvc.db, &metadata).PlanSyncPermissionMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plan.CreatePermissions, []string{"apps-meta-events-get"}) {
+		t.Errorf("CreatePermissions = %v", plan.CreatePermissions)
+	}
+	if !reflect.DeepEqual(plan.DeletePermissions, []string{"apps-list-get"}) {
+		t.Errorf("DeletePermissions = %v", plan.DeletePermissions)
+	}
+}
+
+func TestPermissionService_PlanSyncPermissionMetadata_MatchesSync(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "plan.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := New(db, _permissionSvc.metadata)
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 权限列表为空的权限组保留已有关系，其余权限组按元数据删除多余关系
+	metadata := *_permissionSvc.metadata
+	metadata.PermissionGroups = []*PermissionGroupItem{
+		{
+			Name:  "app-manage",
+			Title: "应用管理",
+			PermissionGroups: []*PermissionGroupItem{
+				{
+					Name:        "app-post-manage",
+					Title:       "应用管理",
+					Permissions: []string{"app-posts-list-get", "app-posts-get"},
+				},
+			},
+		},
+	}
+	svc = New(db, &metadata)
+	plan, err := svc.PlanSyncPermissionMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.CreatePermissionGroupPermissions) != 0 {
+		t.Errorf("CreatePermissionGroupPermissions = %v, want empty", plan.CreatePermissionGroupPermissions)
+	}
+	var planned []string
+	for _, p := range plan.DeletePermissionGroupPermissions {
+		planned = append(planned, p.PermissionGroupName+"_"+p.PermissionName)
+	}
+	sort.Strings(planned)
+
+	listLinks := func() map[string]struct{} {
+		var links []*PermissionGroupPermission
+		if err := db.Find(&links).Error; err != nil {
+			t.Fatal(err)
+		}
+		linksMap := make(map[string]struct{}, len(links))
+		for _, p := range links {
+			linksMap[p.PermissionGroupName+"_"+p.PermissionName] = struct{}{}
+		}
+		return linksMap
+	}
+	before := listLinks()
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	after := listLinks()
+	var deleted []string
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(deleted)
+	if len(after) != len(before)-len(deleted) {
+		t.Errorf("links after sync = %d, want %d", len(after), len(before)-len(deleted))
+	}
+	if !reflect.DeepEqual(planned, deleted) {
+		t.Errorf("planned DeletePermissionGroupPermissions = %v, synced deleted = %v", planned, deleted)
+	}
+	if _, ok := after["app-manage_apps-list-get"]; !ok {
+		t.Errorf("link app-manage_apps-list-get deleted, want kept for empty permissions")
+	}
+
+	plan, err = svc.PlanSyncPermissionMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.IsEmpty() {
+		t.Errorf("PermissionService.PlanSyncPermissionMetadata() after sync = %+v, want empty plan", plan)
+	}
+}
+
+func TestPermissionService_CasbinPolicies(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1002)
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	role, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             "editor",
+		Title:            "编辑",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       1,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		RoleIDs:      []int64{role.ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := _permissionSvc.ExportCasbinPolicies(ctx, ExportCasbinPoliciesParam{RoleableType: roleableType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteCasbinPolicies(&buf, policies); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseCasbinPolicies(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(policies, parsed) {
+		t.Fatalf("ParseCasbinPolicies() = %v, want %v", parsed, policies)
+	}
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "casbin.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := New(db, _permissionSvc.metadata)
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ImportCasbinPolicies(ctx, parsed); err != nil {
+		t.Fatal(err)
+	}
+	reexported, err := svc.ExportCasbinPolicies(ctx, ExportCasbinPoliciesParam{RoleableType: roleableType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(policies, reexported) {
+		t.Errorf("ExportCasbinPolicies() round trip = %v, want %v", reexported, policies)
+	}
+
+	ok, err := svc.HasPermission(ctx, HasPermissionParam{
+		UserID:       1,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		Resource:     "/api/v1/apps/:id/posts",
+		Action:       "GET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Errorf("HasPermission() after import = false, want true")
+	}
+
+	if _, err := svc.ImportCasbinPolicies(ctx, []*CasbinPolicy{
+		{PolicyType: CasbinPolicyTypeP, Values: []string{"viewer", "app:1", "/api/v1/apps", "GET"}},
+	}); err == nil {
+		t.Errorf("ImportCasbinPolicies() with partial permission group error = nil, want error")
+	}
+}
+
+func TestPermissionService_CasbinPoliciesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1)
+	// 菜单权限组没有权限，只能通过 g2 策略还原
+	metadata := *_permissionSvc.metadata
+	metadata.PermissionGroups = append(slices.Clone(metadata.PermissionGroups), &PermissionGroupItem{
+		Name:  "app-menu",
+		Title: "应用菜单",
+		PermissionGroups: []*PermissionGroupItem{
+			{Name: "app-menu-apps", Title: "应用列表", Permissions: []string{"apps-list-get"}},
+		},
+	})
+	newService := func(name string) *PermissionService {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), name)), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc := New(db, &metadata)
+		if err := svc.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := svc.SyncPermissionMetadata(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return svc
+	}
+
+	source := newService("source.db")
+	if err := source.SyncPresetRoles(source.db.WithContext(ctx), roleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	var roleIDs []int64
+	for name, permissionGroups := range map[string][]string{
+		"menu-viewer": {"app-menu", "app-menu-apps"},
+		"lister":      {"app-menu-apps"},
+	} {
+		role, err := source.CreateRole(ctx, CreateRoleParam{
+			RoleableType:     roleableType,
+			RoleableID:       roleableID,
+			Name:             name,
+			Title:            name,
+			PermissionGroups: permissionGroups,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		roleIDs = append(roleIDs, role.ID)
+	}
+	if err := source.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       1,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		RoleIDs:      roleIDs,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := source.ExportCasbinPolicies(ctx, ExportCasbinPoliciesParam{RoleableType: roleableType, WithPermissionGroups: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteCasbinPolicies(&buf, policies); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseCasbinPolicies(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := newService("target.db")
+	if _, err := target.ImportCasbinPolicies(ctx, parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	// casbin 策略不包含角色标题和描述，只比较角色的权限组
+	exportRoles := func(svc *PermissionService) (*PermissionMetadata, map[string][]string) {
+		exported, err := svc.ExportPermissionMetadata(ctx, ExportPermissionMetadataParam{RoleableType: roleableType, RoleableID: roleableID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rolePermissionGroups := make(map[string][]string, len(exported.Roles))
+		for _, r := range exported.Roles {
+			rolePermissionGroups[r.Name] = r.PermissionGroups
+		}
+		exported.Roles = nil
+		return exported, rolePermissionGroups
+	}
+	sourceMetadata, sourceRoles := exportRoles(source)
+	targetMetadata, targetRoles := exportRoles(target)
+	if !reflect.DeepEqual(sourceMetadata, targetMetadata) {
+		t.Errorf("ExportPermissionMetadata() after import = %+v, want %+v", targetMetadata, sourceMetadata)
+	}
+	if !reflect.DeepEqual(sourceRoles, targetRoles) {
+		t.Errorf("ExportPermissionMetadata() roles after import = %v, want %v", targetRoles, sourceRoles)
+	}
+	userRoles, err := target.GetUserRoles(ctx, 1, roleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userRoles) != 2 {
+		t.Errorf("GetUserRoles() after import = %v, want 2 roles", userRoles)
+	}
+
+	// g2 权限组的权限超出 p 策略时返回错误
+	if _, err := target.ImportCasbinPolicies(ctx, []*CasbinPolicy{
+		{PolicyType: CasbinPolicyTypeP, Values: []string{"viewer", "app:2", "/api/v1/apps", "GET"}},
+		{PolicyType: CasbinPolicyTypeG2, Values: []string{"viewer", "app-manage", "app:2"}},
+	}); err == nil {
+		t.Errorf("ImportCasbinPolicies() with g2 permission group exceeding p policies error = nil, want error")
+	}
+	if _, err := target.ImportCasbinPolicies(ctx, []*CasbinPolicy{
+		{PolicyType: CasbinPolicyTypeG2, Values: []string{"viewer", "not-existed", "app:2"}},
+	}); !errors.Is(err, ErrPermissionGroupNotFound) {
+		t.Errorf("ImportCasbinPolicies() with not existed g2 permission group error = %v, want %v", err, ErrPermissionGroupNotFound)
+	}
+}
+
+func TestPermissionService_Explain(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1003)
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := _permissionSvc.GetRoles(ctx, roleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       1,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		RoleIDs:      []int64{roles[0].ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		param           HasPermissionParam
+		wantAllowed     bool
+		wantMissingLink string
+	}{
+		{
+			name:            "permission not found",
+			param:           HasPermissionParam{UserID: 1, RoleableType: roleableType, RoleableID: roleableID, Resource: "/api/v1/apps/:id/meta/events", Action: "GET"},
+			wantMissingLink: MissingLinkPermission,
+		},
+		{
+			name:            "role not found",
+			param:           HasPermissionParam{UserID: 1, RoleableType: roleableType, RoleableID: 9999, Resource: "/api/v1/apps", Action: "GET"},
+			wantMissingLink: MissingLinkRole,
+		},
+		{
+			name:            "user role not found",
+			param:           HasPermissionParam{UserID: 2, RoleableType: roleableType, RoleableID: roleableID, Resource: "/api/v1/apps", Action: "GET"},
+			wantMissingLink: MissingLinkUserRole,
+		},
+		{
+			name:        "allowed",
+			param:       HasPermissionParam{UserID: 1, RoleableType: roleableType, RoleableID: roleableID, Resource: "/api/v1/apps", Action: "GET"},
+			wantAllowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := _permissionSvc.Explain(ctx, tt.param)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Allowed != tt.wantAllowed || got.MissingLink != tt.wantMissingLink {
+				t.Errorf("PermissionService.Explain() = %v %s, want %v %s", got.Allowed, got.MissingLink, tt.wantAllowed, tt.wantMissingLink)
+			}
+			ok, err := _permissionSvc.HasPermission(ctx, tt.param)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != got.Allowed {
+				t.Errorf("PermissionService.HasPermission() = %v, want %v", ok, got.Allowed)
+			}
+		})
+	}
+}
+
+func TestPermissionService_QueryRoles(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1004)
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	var roleIDs []int64
+	for _, name := range []string{"editor", "viewer", "post_100%"} {
+		role, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+			RoleableType:     roleableType,
+			RoleableID:       roleableID,
+			Name:             name,
+			Title:            name,
+			PermissionGroups: []string{"app-post-manage"},
+			CreatorUserID:    7,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		roleIDs = append(roleIDs, role.ID)
+	}
+	for userID := int64(1); userID <= 2; userID++ {
+		if err := _permissionSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+			UserID:       userID,
+			RoleableType: roleableType,
+			RoleableID:   roleableID,
+			RoleIDs:      roleIDs[:userID],
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		param     QueryRolesParam
+		wantNames []string
+		wantTotal int64
+	}{
+		{
+			name:      "all",
+			param:     QueryRolesParam{},
+			wantNames: []string{"admin", "editor", "viewer", "post_100%"},
+			wantTotal: 4,
+		},
+		{
+			name:      "preset",
+			param:     QueryRolesParam{Kind: RoleKindPreset},
+			wantNames: []string{"admin"},
+			wantTotal: 1,
+		},
+		{
+			name:      "custom by creator order by member count",
+			param:     QueryRolesParam{Kind: RoleKindCustom, CreatorUserID: 7, OrderBy: RoleOrderByMemberCount, Desc: true},
+			wantNames: []string{"editor", "viewer", "post_100%"},
+			wantTotal: 3,
+		},
+		{
+			name:      "keyword with wildcard",
+			param:     QueryRolesParam{Keyword: "100%"},
+			wantNames: []string{"post_100%"},
+			wantTotal: 1,
+		},
+		{
+			name:      "user roles",
+			param:     QueryRolesParam{UserID: 2, OrderBy: RoleOrderByName},
+			wantNames: []string{"editor", "viewer"},
+			wantTotal: 2,
+		},
+		{
+			name:      "offset",
+			param:     QueryRolesParam{OrderBy: RoleOrderByName, Limit: 2, Offset: 2},
+			wantNames: []string{"post_100%", "viewer"},
+			wantTotal: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.param.RoleableType = roleableType
+			tt.param.RoleableID = roleableID
+			got, err := _permissionSvc.QueryRoles(ctx, tt.param)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range got.Roles {
+				names = append(names, r.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) || got.Total != tt.wantTotal {
+				t.Errorf("PermissionService.QueryRoles() = %v %d, want %v %d", names, got.Total, tt.wantNames, tt.wantTotal)
+			}
+		})
+	}
+
+	t.Run("cursor", func(t *testing.T) {
+		param := QueryRolesParam{RoleableType: roleableType, RoleableID: roleableID, OrderBy: RoleOrderByMemberCount, Desc: true, Limit: 1}
+		var names []string
+		var memberCounts []int64
+		for {
+			got, err := _permissionSvc.QueryRoles(ctx, param)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range got.Roles {
+				names = append(names, r.Name)
+				memberCounts = append(memberCounts, r.MemberCount)
+			}
+			if got.NextCursor == "" {
+				break
+			}
+			param.Cursor = got.NextCursor
+		}
+		if !reflect.DeepEqual(names, []string{"editor", "viewer", "post_100%", "admin"}) || !reflect.DeepEqual(memberCounts, []int64{2, 1, 0, 0}) {
+			t.Errorf("PermissionService.QueryRoles() = %v %v", names, memberCounts)
+		}
+	})
+}
+
+func TestPermissionService_CloneRoleableRoles(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	sourceRoleableID := int64(1005)
+	targetRoleableID := int64(1006)
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), sourceRoleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), targetRoleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := _permissionSvc.GetRoles(ctx, sourceRoleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editor, err := _permissionSvc.CloneRole(ctx, CloneRoleParam{
+		RoleID:                  roles[0].ID,
+		Name:                    "editor",
+		Title:                   "编辑",
+		ExcludePermissionGroups: []string{"app-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissionGroupNames, err := _permissionSvc.GetRolePermissionGroupNames(ctx, editor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(permissionGroupNames, []string{"app-post-manage"}) {
+		t.Errorf("PermissionService.CloneRole() permission groups = %v", permissionGroupNames)
+	}
+	if _, err := _permissionSvc.CloneRole(ctx, CloneRoleParam{RoleID: roles[0].ID, Name: "editor"}); !errors.Is(err, ErrRoleAlreadyExists) {
+		t.Errorf("PermissionService.CloneRole() error = %v, want %v", err, ErrRoleAlreadyExists)
+	}
+
+	if err := _permissionSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       1,
+		RoleableType: roleableType,
+		RoleableID:   sourceRoleableID,
+		RoleIDs:      []int64{editor.ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cloned, err := _permissionSvc.CloneRoleableRoles(ctx, CloneRoleableRolesParam{
+		SourceRoleableType: roleableType,
+		SourceRoleableID:   sourceRoleableID,
+		TargetRoleableType: roleableType,
+		TargetRoleableID:   targetRoleableID,
+		WithUserRoles:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cloned) != 2 {
+		t.Errorf("len(PermissionService.CloneRoleableRoles()) = %d, want 2", len(cloned))
+	}
+	userRoles, err := _permissionSvc.GetUserRoles(ctx, 1, targetRoleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userRoles) != 1 || userRoles[0].Name != "editor" {
+		t.Errorf("PermissionService.GetUserRoles() after clone = %v", userRoles)
+	}
+
+	// 没有权限组的源角色复制为没有权限组的角色，目标对象下的同名角色权限组被清空
+	emptyRole, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       sourceRoleableID,
+		Name:             "empty",
+		Title:            "无权限",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.db.Where("role_id = ?", emptyRole.ID).Delete(&RolePermissionGroup{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	cloned, err = _permissionSvc.CloneRoleableRoles(ctx, CloneRoleableRolesParam{
+		SourceRoleableType: roleableType,
+		SourceRoleableID:   sourceRoleableID,
+		TargetRoleableType: roleableType,
+		TargetRoleableID:   targetRoleableID,
+	})
+	if err != nil {
+		t.Fatalf("PermissionService.CloneRoleableRoles() with empty permission groups role error = %v", err)
+	}
+	if len(cloned) != 3 || cloned[2].Name != "empty" {
+		t.Fatalf("PermissionService.CloneRoleableRoles() = %v, want 3 roles", cloned)
+	}
+	permissionGroupNames, err = _permissionSvc.GetRolePermissionGroupNames(ctx, cloned[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissionGroupNames) != 0 {
+		t.Errorf("PermissionService.GetRolePermissionGroupNames() of cloned empty role = %v, want empty", permissionGroupNames)
+	}
+}
+
+func TestPermissionService_SoftDeleteRole(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1007)
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := _permissionSvc.GetRoles(ctx, roleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := roles[0]
+	if err := _permissionSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       1,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		RoleIDs:      []int64{role.ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := _permissionSvc.DeleteRole(ctx, role.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.DeleteRole(ctx, role.ID); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("PermissionService.DeleteRole() error = %v, want %v", err, ErrRoleNotFound)
+	}
+	userRoles, err := _permissionSvc.GetUserRoles(ctx, 1, roleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userRoles) != 0 {
+		t.Errorf("PermissionService.GetUserRoles() after delete = %v", userRoles)
+	}
+	deletedRoles, err := _permissionSvc.GetDeletedRoles(ctx, roleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deletedRoles) != 1 || deletedRoles[0].ID != role.ID {
+		t.Errorf("PermissionService.GetDeletedRoles() = %v", deletedRoles)
+	}
+
+	if _, err := _permissionSvc.RestoreRole(ctx, role.ID); err != nil {
+		t.Fatal(err)
+	}
+	userRoles, err = _permissionSvc.GetUserRoles(ctx, 1, roleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userRoles) != 1 || userRoles[0].ID != role.ID {
+		t.Errorf("PermissionService.GetUserRoles() after restore = %v", userRoles)
+	}
+
+	if err := _permissionSvc.DeleteRole(ctx, role.ID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	purged, err := _permissionSvc.PurgeDeletedRoles(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged < 1 {
+		t.Errorf("PermissionService.PurgeDeletedRoles() = %d, want >= 1", purged)
+	}
+	if _, err := _permissionSvc.RestoreRole(ctx, role.ID); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("PermissionService.RestoreRole() error = %v, want %v", err, ErrRoleNotFound)
+	}
+}
+
+func TestPermissionService_VersionConflict(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1008)
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := _permissionSvc.GetRoles(ctx, roleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := roles[0]
+
+	updated, err := _permissionSvc.UpdateRole(ctx, UpdateRoleParam{
+		ID:               role.ID,
+		Version:          role.Version,
+		Title:            "管理员A",
+		PermissionGroups: []string{"app-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != role.Version+1 || updated.Title != "管理员A" {
+		t.Errorf("PermissionService.UpdateRole() = %+v", updated)
+	}
+	_, err = _permissionSvc.UpdateRole(ctx, UpdateRoleParam{
+		ID:               role.ID,
+		Version:          role.Version,
+		Title:            "管理员B",
+		PermissionGroups: []string{"app-manage"},
+	})
+	var conflictErr *VersionConflictError
+	if !errors.Is(err, ErrVersionConflict) || !errors.As(err, &conflictErr) {
+		t.Fatalf("PermissionService.UpdateRole() error = %v, want %v", err, ErrVersionConflict)
+	}
+
+	// 同名创建被拒绝，角色的权限组和版本都不变，持有当前版本的更新仍然可以成功
+	if _, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             role.Name,
+		Title:            role.Title,
+		PermissionGroups: []string{"app-post-manage"},
+	}); !errors.Is(err, ErrRoleAlreadyExists) {
+		t.Fatalf("PermissionService.CreateRole() error = %v, want %v", err, ErrRoleAlreadyExists)
+	}
+	_, err = _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             "missing-groups",
+		Title:            "缺失权限组",
+		PermissionGroups: []string{"app-manage", "not-existed-a", "not-existed-b"},
+	})
+	if !errors.Is(err, ErrPermissionGroupNotFound) || !strings.HasSuffix(err.Error(), ": not-existed-a, not-existed-b") {
+		t.Errorf("PermissionService.CreateRole() error = %v, want %v with missing names", err, ErrPermissionGroupNotFound)
+	}
+	if names, err := _permissionSvc.GetRolePermissionGroupNames(ctx, role.ID); err != nil || !reflect.DeepEqual(names, []string{"app-manage"}) {
+		t.Errorf("PermissionService.GetRolePermissionGroupNames() = %v, %v, want [app-manage]", names, err)
+	}
+	if _, err := _permissionSvc.UpdateRole(ctx, UpdateRoleParam{
+		ID:               role.ID,
+		Version:          updated.Version,
+		Title:            updated.Title,
+		PermissionGroups: []string{"app-manage"},
+	}); err != nil {
+		t.Errorf("PermissionService.UpdateRole() error = %v", err)
+	}
+
+	version, err := _permissionSvc.GetUserRolesVersion(ctx, 1, roleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	param := AssignRolesToUserParam{
+		UserID:       1,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		RoleIDs:      []int64{role.ID},
+		Version:      version,
+	}
+	if err := _permissionSvc.AssignRolesToUser(ctx, param); err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.AssignRolesToUser(ctx, param); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("PermissionService.AssignRolesToUser() error = %v, want %v", err, ErrVersionConflict)
+	}
+}
+
+func TestPermissionService_UserRolesVersion(t *testing.T) {
+	ctx := context.Background()
+	// 使用 BEGIN IMMEDIATE 让并发事务在 sqlite 中排队而不是直接返回 database is locked
+	db, err := gorm.Open(sqlite.Open("file:"+filepath.Join(t.TempDir(), "version.db")+"?_txlock=immediate&_busy_timeout=5000"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := New(db, _permissionSvc.metadata)
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPresetRoles(db, 1, "app"); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := svc.GetRoles(ctx, 1, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	editor, err := svc.CreateRole(ctx, CreateRoleParam{RoleableType: "app", RoleableID: 1, Name: "editor", Title: "编辑", PermissionGroups: []string{"app-post-manage"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 角色集合改回原样后旧版本同样失效
+	version, err := svc.GetUserRolesVersion(ctx, 1, 1, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "0" {
+		t.Errorf("PermissionService.GetUserRolesVersion() = %s, want 0", version)
+	}
+	change := ChangeUserRolesParam{UserID: 1, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{editor.ID}}
+	if err := svc.AddUserRoles(ctx, change); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RemoveUserRoles(ctx, change); err != nil {
+		t.Fatal(err)
+	}
+	param := AssignRolesToUserParam{UserID: 1, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{admin[0].ID}, Version: version}
+	if err := svc.AssignRolesToUser(ctx, param); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("PermissionService.AssignRolesToUser() after change back error = %v, want %v", err, ErrVersionConflict)
+	}
+	if param.Version, err = svc.GetUserRolesVersion(ctx, 1, 1, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.AssignRolesToUser(ctx, param); err != nil {
+		t.Fatal(err)
+	}
+
+	// 删除和恢复角色同样改变成员的版本
+	if version, err = svc.GetUserRolesVersion(ctx, 1, 1, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.DeleteRole(ctx, admin[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.RestoreRole(ctx, admin[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := svc.GetUserRolesVersion(ctx, 1, 1, "app"); err != nil || got == version {
+		t.Errorf("PermissionService.GetUserRolesVersion() after delete and restore = %s, %v, want not %s", got, err, version)
+	}
+
+	// 持有同一版本的两个事务同时分配，只有一个成功
+	if version, err = svc.GetUserRolesVersion(ctx, 2, 1, "app"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, 2)
+	for i, roleID := range []int64{admin[0].ID, editor.ID} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = svc.AssignRolesToUser(ctx, AssignRolesToUserParam{UserID: 2, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{roleID}, Version: version})
+		}()
+	}
+	close(start)
+	wg.Wait()
+	var succeeded, conflicted int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrVersionConflict):
+			conflicted++
+		default:
+			t.Errorf("PermissionService.AssignRolesToUser() error = %v", err)
+		}
+	}
+	if succeeded != 1 || conflicted != 1 {
+		t.Errorf("concurrent PermissionService.AssignRolesToUser() succeeded = %d, conflicted = %d, want 1 and 1", succeeded, conflicted)
+	}
+	if roles, err := svc.GetUserRoles(ctx, 2, 1, "app"); err != nil || len(roles) != 1 {
+		t.Errorf("PermissionService.GetUserRoles() = %v, %v, want 1 role", roles, err)
+	}
+}
+
+func TestPermissionService_MigrateRolesWithoutSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "upgrade.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 支持软删除前的 roles 表结构
+	for _, statement := range []string{
+		"CREATE TABLE `roles` (`id` integer PRIMARY KEY AUTOINCREMENT, `roleable_type` text, `roleable_id` integer, `name` text, `title` text, `description` text, `creator_user_id` integer, `created_at` integer, `updated_at` integer)",
+		"CREATE UNIQUE INDEX `idx_roles_name` ON `roles`(`roleable_type`,`roleable_id`,`name`)",
+		"INSERT INTO `roles` (`roleable_type`, `roleable_id`, `name`, `title`) VALUES ('app', 1, 'editor', '编辑')",
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := New(db, _permissionSvc.metadata)
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	// 再次迁移不重复重建
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	indexes, err := db.Migrator().GetIndexes(&Role{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range indexes {
+		if index.Name() == "idx_roles_name" && !reflect.DeepEqual(index.Columns(), []string{"roleable_type", "roleable_id", "name", "deleted_at"}) {
+			t.Errorf("idx_roles_name columns = %v", index.Columns())
+		}
+	}
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := svc.GetRoles(ctx, 1, "app")
+	if err != nil || len(roles) != 1 {
+		t.Fatalf("PermissionService.GetRoles() = %v, %v, want the existed role", roles, err)
+	}
+	if err := svc.DeleteRole(ctx, roles[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CreateRole(ctx, CreateRoleParam{RoleableType: "app", RoleableID: 1, Name: "editor", Title: "编辑", PermissionGroups: []string{"app-post-manage"}}); err != nil {
+		t.Fatalf("PermissionService.CreateRole() with deleted role name error = %v", err)
+	}
+	if _, err := svc.RestoreRole(ctx, roles[0].ID); !errors.Is(err, ErrRoleAlreadyExists) {
+		t.Errorf("PermissionService.RestoreRole() error = %v, want %v", err, ErrRoleAlreadyExists)
+	}
+}
+
+func TestPermissionService_Hooks(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "hook.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var eventTypes []string
+	errHook := errors.New("hook failed")
+	svc := New(db, _permissionSvc.metadata,
+		WithHook(HookFunc(func(ctx context.Context, event *Event) error {
+			eventTypes = append(eventTypes, event.Type)
+			return nil
+		})),
+		WithTxHook(HookFunc(func(ctx context.Context, event *Event) error {
+			if event.Tx == nil {
+				t.Errorf("Event.Tx is nil in tx hook")
+			}
+			if after, ok := event.After.(*RoleSnapshot); ok && after.Role.Name == "rejected" {
+				return errHook
+			}
+			return nil
+		})),
+	)
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	// 第二次同步时元数据没有变化，不发出 metadata.synced 事件
+	for i := 0; i < 2; i++ {
+		if err := svc.SyncPermissionMetadata(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	role, err := svc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     "app",
+		RoleableID:       1,
+		Name:             "editor",
+		Title:            "编辑",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.UpdateRole(ctx, UpdateRoleParam{
+		ID:               role.ID,
+		Title:            "编辑者",
+		PermissionGroups: []string{"app-post-manage"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := svc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+			UserID:       1,
+			RoleableType: "app",
+			RoleableID:   1,
+			RoleIDs:      []int64{role.ID},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svc.DeleteRole(ctx, role.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.RestoreRole(ctx, role.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	wantEventTypes := []string{EventMetadataSynced, EventRoleCreated, EventRoleUpdated, EventUserRolesChanged, EventRoleDeleted, EventRoleRestored}
+	if !reflect.DeepEqual(eventTypes, wantEventTypes) {
+		t.Errorf("event types = %v, want %v", eventTypes, wantEventTypes)
+	}
+
+	// 只修改多语言标题时也发出 metadata.synced 事件
+	metadata := *_permissionSvc.metadata
+	metadata.Permissions = slices.Clone(metadata.Permissions)
+	permission := *metadata.Permissions[0]
+	permission.Titles = map[string]string{"en": "List apps"}
+	metadata.Permissions[0] = &permission
+	var syncedEvents []*Event
+	translatedSvc := New(db, &metadata, WithHook(HookFunc(func(ctx context.Context, event *Event) error {
+		syncedEvents = append(syncedEvents, event)
+		return nil
+	})))
+	for i := 0; i < 2; i++ {
+		if err := translatedSvc.SyncPermissionMetadata(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(syncedEvents) != 1 || syncedEvents[0].Type != EventMetadataSynced {
+		t.Errorf("events after syncing translations = %v, want one %s", syncedEvents, EventMetadataSynced)
+	} else {
+		for _, p := range syncedEvents[0].After.(*PermissionMetadata).Permissions {
+			if p.Name == permission.Name && !reflect.DeepEqual(p.Titles, permission.Titles) {
+				t.Errorf("metadata.synced After titles = %v, want %v", p.Titles, permission.Titles)
+			}
+		}
+	}
+
+	if _, err := svc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     "app",
+		RoleableID:       1,
+		Name:             "rejected",
+		Title:            "拒绝",
+		PermissionGroups: []string{"app-post-manage"},
+	}); !errors.Is(err, errHook) {
+		t.Errorf("PermissionService.CreateRole() error = %v, want %v", err, errHook)
+	}
+	roles, err := svc.GetRoles(ctx, 1, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 {
+		t.Errorf("len(PermissionService.GetRoles()) = %d, want 1 after tx hook rollback", len(roles))
+	}
+}
+
+func TestPermissionService_ChangeNotifier(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notify.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inProcessNotifier := NewInProcessChangeNotifier()
+	var inProcessChanges []*PermissionChange
+	unsubscribe := inProcessNotifier.Subscribe(func(change *PermissionChange) {
+		inProcessChanges = append(inProcessChanges, change)
+	})
+	defer unsubscribe()
+
+	pollingNotifier := NewPollingChangeNotifier(db, time.Second, time.Hour)
+	if err := pollingNotifier.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pollingNotifier.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := New(db, _permissionSvc.metadata, WithChangeNotifier(inProcessNotifier))
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	// 另一个实例通过变更表发布变更
+	replica := New(db, _permissionSvc.metadata, WithChangeNotifier(pollingNotifier))
+	var pollingChanges []*PermissionChange
+	replica.SubscribeChanges(func(change *PermissionChange) {
+		pollingChanges = append(pollingChanges, change)
+	})
+
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	role, err := replica.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     "app",
+		RoleableID:       1,
+		Name:             "editor",
+		Title:            "编辑",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       2,
+		RoleableType: "app",
+		RoleableID:   1,
+		RoleIDs:      []int64{role.ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inProcessChanges) != 1 || inProcessChanges[0].Type != EventMetadataSynced {
+		t.Errorf("InProcessChangeNotifier changes = %v", inProcessChanges)
+	}
+	n, err := pollingNotifier.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(pollingChanges) != 2 {
+		t.Fatalf("PollingChangeNotifier.Poll() = %d, changes = %v", n, pollingChanges)
+	}
+	if got := pollingChanges[0]; got.Type != EventRoleCreated || got.RoleID != role.ID || got.RoleableType != "app" || got.RoleableID != 1 {
+		t.Errorf("PollingChangeNotifier changes[0] = %+v", got)
+	}
+	if got := pollingChanges[1]; got.Type != EventUserRolesChanged || got.UserID != 2 {
+		t.Errorf("PollingChangeNotifier changes[1] = %+v", got)
+	}
+}
+
+func TestPermissionService_MinHoldersRules(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "holder.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := New(db, _permissionSvc.metadata, WithMinHoldersRules(MinHoldersRule{
+		RoleableType:     "app",
+		PermissionGroups: []string{"app-manage"},
+		MinHolders:       1,
+	}))
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPresetRoles(db, 1, "app"); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := svc.GetRoles(ctx, 1, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := roles[0]
+	editor, err := svc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     "app",
+		RoleableID:       1,
+		Name:             "editor",
+		Title:            "编辑",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 还没有管理员时不限制
+	if err := svc.AssignRolesToUser(ctx, AssignRolesToUserParam{UserID: 1, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{editor.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.AssignRolesToUser(ctx, AssignRolesToUserParam{UserID: 1, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{admin.ID}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var minHoldersErr *MinHoldersError
+	err = svc.AssignRolesToUser(ctx, AssignRolesToUserParam{UserID: 1, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{editor.ID}})
+	if !errors.Is(err, ErrMinHoldersViolated) || !errors.As(err, &minHoldersErr) || minHoldersErr.Holders != 0 {
+		t.Errorf("PermissionService.AssignRolesToUser() error = %v, want %v", err, ErrMinHoldersViolated)
+	}
+	if err := svc.DeleteRole(ctx, admin.ID); !errors.Is(err, ErrMinHoldersViolated) {
+		t.Errorf("PermissionService.DeleteRole() error = %v, want %v", err, ErrMinHoldersViolated)
+	}
+	if _, err := svc.UpdateRole(ctx, UpdateRoleParam{ID: admin.ID, Title: admin.Title, PermissionGroups: []string{"app-post-manage"}}); !errors.Is(err, ErrMinHoldersViolated) {
+		t.Errorf("PermissionService.UpdateRole() error = %v, want %v", err, ErrMinHoldersViolated)
+	}
+	// 同名创建不能覆盖已有角色的权限组
+	if _, err := svc.CreateRole(ctx, CreateRoleParam{RoleableType: "app", RoleableID: 1, Name: admin.Name, Title: admin.Title, PermissionGroups: []string{"app-post-manage"}}); !errors.Is(err, ErrRoleAlreadyExists) {
+		t.Errorf("PermissionService.CreateRole() error = %v, want %v", err, ErrRoleAlreadyExists)
+	}
+	if ok, err := svc.HasPermissionGroup(ctx, HasPermissionGroupParam{UserID: 1, RoleableType: "app", RoleableID: 1, PermissionGroupName: "app-manage"}); err != nil || !ok {
+		t.Errorf("PermissionService.HasPermissionGroup() = %v, %v, want true", ok, err)
+	}
+
+	// 有其他管理员后可以移除
+	if err := svc.AssignRolesToUser(ctx, AssignRolesToUserParam{UserID: 2, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{admin.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.AssignRolesToUser(ctx, AssignRolesToUserParam{UserID: 1, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{editor.ID}}); err != nil {
+		t.Errorf("PermissionService.AssignRolesToUser() error = %v", err)
+	}
+}
+
+func TestPermissionService_RoleConstraints(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "constraint.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := *_permissionSvc.metadata
+	metadata.RoleConstraints = []*RoleConstraintItem{
+		{RoleableType: "app", Name: "publish-review", ExclusiveRoles: []string{"publisher", "reviewer"}},
+		{RoleableType: "app", Name: "max-roles", MaxRoles: 2},
+	}
+	if err := metadata.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// 未配置约束的服务用于构造已存在的违规
+	unconstrainedSvc := New(db, _permissionSvc.metadata)
+	svc := New(db, &metadata)
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	roleIDsMap := make(map[string]int64)
+	for _, name := range []string{"publisher", "reviewer", "viewer"} {
+		role, err := svc.CreateRole(ctx, CreateRoleParam{
+			RoleableType:     "app",
+			RoleableID:       1,
+			Name:             name,
+			Title:            name,
+			PermissionGroups: []string{"app-post-manage"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		roleIDsMap[name] = role.ID
+	}
+
+	tests := []struct {
+		name      string
+		roleNames []string
+		wantErr   error
+	}{
+		{name: "exclusive roles", roleNames: []string{"publisher", "reviewer"}, wantErr: ErrRoleConstraintViolated},
+		{name: "max roles", roleNames: []string{"publisher", "viewer", "reviewer"}, wantErr: ErrRoleConstraintViolated},
+		{name: "allowed", roleNames: []string{"publisher", "viewer"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var roleIDs []int64
+			for _, name := range tt.roleNames {
+				roleIDs = append(roleIDs, roleIDsMap[name])
+			}
+			err := svc.AssignRolesToUser(ctx, AssignRolesToUserParam{UserID: 1, RoleableType: "app", RoleableID: 1, RoleIDs: roleIDs})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("PermissionService.AssignRolesToUser() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := unconstrainedSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       2,
+		RoleableType: "app",
+		RoleableID:   1,
+		RoleIDs:      []int64{roleIDsMap["publisher"], roleIDsMap["reviewer"]},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	violations, err := svc.GetRoleConstraintViolations(ctx, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*RoleConstraintViolation{
+		{Constraint: "publish-review", UserID: 2, RoleableType: "app", RoleableID: 1, RoleNames: []string{"publisher", "reviewer"}},
+	}
+	if !reflect.DeepEqual(violations, want) {
+		t.Errorf("PermissionService.GetRoleConstraintViolations() = %v, want %v", violations, want)
+	}
+}
+
+func TestPermissionService_PrivilegeEscalation(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1009)
+	manager, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             "manager",
+		Title:            "经理",
+		PermissionGroups: []string{"app-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       1,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		RoleIDs:      []int64{manager.ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = _permissionSvc.CreateRoleAs(ctx, 1, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             "editor",
+		Title:            "编辑",
+		PermissionGroups: []string{"app-manage", "app-post-manage"},
+	})
+	var escalationErr *PrivilegeEscalationError
+	if !errors.As(err, &escalationErr) || !reflect.DeepEqual(escalationErr.PermissionGroups, []string{"app-post-manage"}) {
+		t.Fatalf("PermissionService.CreateRoleAs() error = %v, want %v", err, ErrPrivilegeEscalation)
+	}
+
+	// 没有 actor 的版本不受限制
+	editor, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             "editor",
+		Title:            "编辑",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := _permissionSvc.UpdateRoleAs(ctx, 1, UpdateRoleParam{ID: manager.ID, Title: "经理", PermissionGroups: []string{"app-manage", "app-post-manage"}}); !errors.Is(err, ErrPrivilegeEscalation) {
+		t.Errorf("PermissionService.UpdateRoleAs() error = %v, want %v", err, ErrPrivilegeEscalation)
+	}
+	// 保留自己没有的权限组不受限制
+	if _, err := _permissionSvc.UpdateRoleAs(ctx, 1, UpdateRoleParam{ID: editor.ID, Title: "编辑者", PermissionGroups: []string{"app-post-manage"}}); err != nil {
+		t.Errorf("PermissionService.UpdateRoleAs() error = %v", err)
+	}
+	if err := _permissionSvc.AssignRolesToUserAs(ctx, 1, AssignRolesToUserParam{UserID: 2, RoleableType: roleableType, RoleableID: roleableID, RoleIDs: []int64{editor.ID}}); !errors.Is(err, ErrPrivilegeEscalation) {
+		t.Errorf("PermissionService.AssignRolesToUserAs() error = %v, want %v", err, ErrPrivilegeEscalation)
+	}
+	if err := _permissionSvc.AssignRolesToUserAs(ctx, 1, AssignRolesToUserParam{UserID: 2, RoleableType: roleableType, RoleableID: roleableID, RoleIDs: []int64{manager.ID}}); err != nil {
+		t.Errorf("PermissionService.AssignRolesToUserAs() error = %v", err)
+	}
+
+	// 同名创建不能修改已有角色
+	if _, err := _permissionSvc.CreateRoleAs(ctx, 1, CreateRoleParam{RoleableType: roleableType, RoleableID: roleableID, Name: "editor", Title: "编辑", PermissionGroups: []string{"app-manage"}}); !errors.Is(err, ErrRoleAlreadyExists) {
+		t.Errorf("PermissionService.CreateRoleAs() error = %v, want %v", err, ErrRoleAlreadyExists)
+	}
+	if names, err := _permissionSvc.GetRolePermissionGroupNames(ctx, editor.ID); err != nil || !reflect.DeepEqual(names, []string{"app-post-manage"}) {
+		t.Errorf("PermissionService.GetRolePermissionGroupNames() = %v, %v, want [app-post-manage]", names, err)
+	}
+
+	if err := _permissionSvc.AddUserRolesAs(ctx, 1, ChangeUserRolesParam{UserID: 3, RoleableType: roleableType, RoleableID: roleableID, RoleIDs: []int64{editor.ID}}); !errors.Is(err, ErrPrivilegeEscalation) {
+		t.Errorf("PermissionService.AddUserRolesAs() error = %v, want %v", err, ErrPrivilegeEscalation)
+	}
+	if err := _permissionSvc.AddUserRolesAs(ctx, 1, ChangeUserRolesParam{UserID: 3, RoleableType: roleableType, RoleableID: roleableID, RoleIDs: []int64{manager.ID}}); err != nil {
+		t.Errorf("PermissionService.AddUserRolesAs() error = %v", err)
+	}
+
+	// 移除自己没有的权限组的角色同样受限制
+	if err := _permissionSvc.AddUserRoles(ctx, ChangeUserRolesParam{UserID: 3, RoleableType: roleableType, RoleableID: roleableID, RoleIDs: []int64{editor.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.RemoveUserRolesAs(ctx, 1, ChangeUserRolesParam{UserID: 3, RoleableType: roleableType, RoleableID: roleableID, RoleIDs: []int64{editor.ID}}); !errors.Is(err, ErrPrivilegeEscalation) {
+		t.Errorf("PermissionService.RemoveUserRolesAs() error = %v, want %v", err, ErrPrivilegeEscalation)
+	}
+	if err := _permissionSvc.AssignRolesToUserAs(ctx, 1, AssignRolesToUserParam{UserID: 3, RoleableType: roleableType, RoleableID: roleableID, RoleIDs: []int64{manager.ID}}); !errors.Is(err, ErrPrivilegeEscalation) {
+		t.Errorf("PermissionService.AssignRolesToUserAs() error = %v, want %v", err, ErrPrivilegeEscalation)
+	}
+	if err := _permissionSvc.RemoveUserRolesAs(ctx, 1, ChangeUserRolesParam{UserID: 3, RoleableType: roleableType, RoleableID: roleableID, RoleIDs: []int64{manager.ID}}); err != nil {
+		t.Errorf("PermissionService.RemoveUserRolesAs() error = %v", err)
+	}
+	if roles, err := _permissionSvc.GetUserRoles(ctx, 3, roleableID, roleableType); err != nil || len(roles) != 1 || roles[0].ID != editor.ID {
+		t.Errorf("PermissionService.GetUserRoles() = %v, %v, want [editor]", roles, err)
+	}
+
+	// 删除和恢复角色会同时移除和授予角色成员的权限组
+	if err := _permissionSvc.DeleteRoleAs(ctx, 1, editor.ID); !errors.Is(err, ErrPrivilegeEscalation) {
+		t.Errorf("PermissionService.DeleteRoleAs() error = %v, want %v", err, ErrPrivilegeEscalation)
+	}
+	if err := _permissionSvc.DeleteRole(ctx, editor.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := _permissionSvc.RestoreRoleAs(ctx, 1, editor.ID); !errors.Is(err, ErrPrivilegeEscalation) {
+		t.Errorf("PermissionService.RestoreRoleAs() error = %v, want %v", err, ErrPrivilegeEscalation)
+	}
+	if roles, err := _permissionSvc.GetUserRoles(ctx, 3, roleableID, roleableType); err != nil || len(roles) != 0 {
+		t.Errorf("PermissionService.GetUserRoles() = %v, %v, want empty", roles, err)
+	}
+	if _, err := _permissionSvc.RestoreRole(ctx, editor.ID); err != nil {
+		t.Fatal(err)
+	}
+	reviewer, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             "reviewer",
+		Title:            "审核",
+		PermissionGroups: []string{"app-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.DeleteRoleAs(ctx, 1, reviewer.ID); err != nil {
+		t.Errorf("PermissionService.DeleteRoleAs() error = %v", err)
+	}
+	if _, err := _permissionSvc.RestoreRoleAs(ctx, 1, reviewer.ID); err != nil {
+		t.Errorf("PermissionService.RestoreRoleAs() error = %v", err)
+	}
+}
+
+func TestPermissionService_AddRemoveUserRoles(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1010)
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	admin := mustGetRoles(t, roleableID, roleableType)[0]
+	editor, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             "editor",
+		Title:            "编辑",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	param := ChangeUserRolesParam{UserID: 1, RoleableType: roleableType, RoleableID: roleableID}
+	userRoleNames := func() []string {
+		roles, err := _permissionSvc.GetUserRoles(ctx, 1, roleableID, roleableType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, r := range roles {
+			names = append(names, r.Name)
+		}
+		return names
+	}
+
+	param.RoleIDs = []int64{admin.ID}
+	if err := _permissionSvc.AddUserRoles(ctx, param); err != nil {
+		t.Fatal(err)
+	}
+	param.RoleIDs = []int64{editor.ID, admin.ID}
+	if err := _permissionSvc.AddUserRoles(ctx, param); err != nil {
+		t.Fatal(err)
+	}
+	if got := userRoleNames(); !reflect.DeepEqual(got, []string{"admin", "editor"}) {
+		t.Errorf("user roles after AddUserRoles = %v", got)
+	}
+	param.RoleIDs = []int64{admin.ID}
+	if err := _permissionSvc.RemoveUserRoles(ctx, param); err != nil {
+		t.Fatal(err)
+	}
+	if got := userRoleNames(); !reflect.DeepEqual(got, []string{"editor"}) {
+		t.Errorf("user roles after RemoveUserRoles = %v", got)
+	}
+
+	// 其他对象下的角色
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID+1, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	otherRoles := mustGetRoles(t, roleableID+1, roleableType)
+	param.RoleIDs = []int64{otherRoles[0].ID}
+	if err := _permissionSvc.AddUserRoles(ctx, param); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("PermissionService.AddUserRoles() error = %v, want %v", err, ErrRoleNotFound)
+	}
+}
+
+func TestPermissionService_BulkAssignRoles(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1012)
+	if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID, roleableType); err != nil {
+		t.Fatal(err)
+	}
+	admin := mustGetRoles(t, roleableID, roleableType)[0]
+	editor, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             "editor",
+		Title:            "编辑",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       3,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		RoleIDs:      []int64{admin.ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := _permissionSvc.BulkAssignRoles(ctx, BulkAssignRolesParam{
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		Users: []*BulkUserRolesItem{
+			{UserID: 1, RoleIDs: []int64{admin.ID, editor.ID}},
+			{UserID: 2, RoleIDs: []int64{editor.ID, 0}},
+			{UserID: 3, RoleIDs: []int64{editor.ID}},
+			{UserID: 1, RoleIDs: []int64{editor.ID}},
+		},
+		BatchSize: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantErrs := []error{nil, ErrRoleNotFound, nil, ErrInvalidArgument}
+	for i, result := range results {
+		if !errors.Is(result.Err, wantErrs[i]) {
+			t.Errorf("BulkAssignRoles() results[%d].Err = %v, want %v", i, result.Err, wantErrs[i])
+		}
+	}
+	if want := []int64{editor.ID}; !reflect.DeepEqual(results[2].Added, want) || len(results[2].Removed) != 0 {
+		t.Errorf("BulkAssignRoles() results[2] = %+v, want added %v", results[2], want)
+	}
+
+	// 替换模式
+	results, err = _permissionSvc.BulkAssignRoles(ctx, BulkAssignRolesParam{
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		Users: []*BulkUserRolesItem{
+			{UserID: 3, RoleIDs: []int64{editor.ID}},
+		},
+		Replace: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{admin.ID}; !reflect.DeepEqual(results[0].Removed, want) || len(results[0].Added) != 0 {
+		t.Errorf("BulkAssignRoles() replace results[0] = %+v, want removed %v", results[0], want)
+	}
+
+	wantUserRoleIDs := map[int64][]int64{
+		1: {admin.ID, editor.ID},
+		2: {},
+		3: {editor.ID},
+	}
+	for userID, want := range wantUserRoleIDs {
+		got, err := _permissionSvc.getUserRoleIDs(_permissionSvc.db, userID, roleableID, roleableType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("user %d role ids = %v, want %v", userID, got, want)
+		}
+	}
+}
+
+func TestPermissionService_RemoveUser(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableIDs := []int64{1013, 1014}
+	var adminRoleIDs []int64
+	for _, roleableID := range roleableIDs {
+		if err := _permissionSvc.SyncPresetRoles(_permissionSvc.db.WithContext(ctx), roleableID, roleableType); err != nil {
+			t.Fatal(err)
+		}
+		adminRoleID := mustGetRoles(t, roleableID, roleableType)[0].ID
+		adminRoleIDs = append(adminRoleIDs, adminRoleID)
+		for _, userID := range []int64{10131, 10132} {
+			if err := _permissionSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+				UserID:       userID,
+				RoleableType: roleableType,
+				RoleableID:   roleableID,
+				RoleIDs:      []int64{adminRoleID},
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// 已软删除角色的用户角色同样被移除，并包括在返回值中
+	deleted, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableIDs[0],
+		Name:             "viewer",
+		Title:            "查看",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []int64{10131, 10132} {
+		if err := _permissionSvc.AddUserRoles(ctx, ChangeUserRolesParam{
+			UserID:       userID,
+			RoleableType: roleableType,
+			RoleableID:   roleableIDs[0],
+			RoleIDs:      []int64{deleted.ID},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := _permissionSvc.DeleteRole(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := _permissionSvc.RemoveUserFromRoleable(ctx, 10131, roleableType, roleableIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &UserRolesSnapshot{UserID: 10131, RoleableType: roleableType, RoleableID: roleableIDs[0], RoleIDs: []int64{adminRoleIDs[0], deleted.ID}}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("PermissionService.RemoveUserFromRoleable() = %+v, want %+v", removed, want)
+	}
+
+	removedList, err := _permissionSvc.RemoveUserEverywhere(ctx, 10132)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantList := []*UserRolesSnapshot{
+		{UserID: 10132, RoleableType: roleableType, RoleableID: roleableIDs[0], RoleIDs: []int64{adminRoleIDs[0], deleted.ID}},
+		{UserID: 10132, RoleableType: roleableType, RoleableID: roleableIDs[1], RoleIDs: adminRoleIDs[1:]},
+	}
+	if !reflect.DeepEqual(removedList, wantList) {
+		t.Errorf("PermissionService.RemoveUserEverywhere() = %+v, want %+v", removedList, wantList)
+	}
+	var deletedUserRoles int64
+	if err := _permissionSvc.db.Model(&UserRole{}).Where("role_id = ?", deleted.ID).Count(&deletedUserRoles).Error; err != nil {
+		t.Fatal(err)
+	}
+	if deletedUserRoles != 0 {
+		t.Errorf("user roles of deleted role = %d, want 0", deletedUserRoles)
+	}
+
+	wantUserRoleIDs := []struct {
+		userID     int64
+		roleableID int64
+		roleIDs    []int64
+	}{
+		{userID: 10131, roleableID: roleableIDs[0], roleIDs: []int64{}},
+		{userID: 10131, roleableID: roleableIDs[1], roleIDs: adminRoleIDs[1:]},
+		{userID: 10132, roleableID: roleableIDs[0], roleIDs: []int64{}},
+		{userID: 10132, roleableID: roleableIDs[1], roleIDs: []int64{}},
+	}
+	for _, w := range wantUserRoleIDs {
+		got, err := _permissionSvc.getUserRoleIDs(_permissionSvc.db, w.userID, w.roleableID, roleableType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, w.roleIDs) {
+			t.Errorf("user %d role ids in %d = %v, want %v", w.userID, w.roleableID, got, w.roleIDs)
+		}
+	}
+}
+
+func TestPermissionService_GCRoleables(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gc.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var eventTypes []string
+	svc := New(db, _permissionSvc.metadata, WithHook(HookFunc(func(ctx context.Context, event *Event) error {
+		eventTypes = append(eventTypes, event.Type)
+		return nil
+	})))
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for roleableID := int64(1); roleableID <= 3; roleableID++ {
+		if err := svc.SyncPresetRoles(db, roleableID, "app"); err != nil {
+			t.Fatal(err)
+		}
+		roles, err := svc.GetRoles(ctx, roleableID, "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := svc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+			UserID:       1,
+			RoleableType: "app",
+			RoleableID:   roleableID,
+			RoleIDs:      []int64{roles[0].ID},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventTypes = nil
+
+	deletedRoleableIDs, err := svc.GCRoleables(ctx, "app", 2, func(ctx context.Context, roleableType string, roleableIDs []int64) ([]int64, error) {
+		return []int64{2}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1, 3}; !reflect.DeepEqual(deletedRoleableIDs, want) {
+		t.Errorf("PermissionService.GCRoleables() = %v, want %v", deletedRoleableIDs, want)
+	}
+	wantEventTypes := []string{EventRoleDeleted, EventUserRolesChanged, EventRoleDeleted, EventUserRolesChanged}
+	if !reflect.DeepEqual(eventTypes, wantEventTypes) {
+		t.Errorf("event types = %v, want %v", eventTypes, wantEventTypes)
+	}
+
+	var roleableIDs []int64
+	if err := db.Model(&Role{}).Distinct("roleable_id").Order("roleable_id").Pluck("roleable_id", &roleableIDs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{2}; !reflect.DeepEqual(roleableIDs, want) {
+		t.Errorf("roleable ids after GCRoleables() = %v, want %v", roleableIDs, want)
+	}
+	var userRolesCount int64
+	if err := db.Model(&UserRole{}).Count(&userRolesCount).Error; err != nil {
+		t.Fatal(err)
+	}
+	if userRolesCount != 1 {
+		t.Errorf("user roles count after GCRoleables() = %d, want 1", userRolesCount)
+	}
+}
+
+func TestPermissionService_BuildUserPermissionGroupTree(t *testing.T) {
+	ctx := context.Background()
+	roleableType := "app"
+	roleableID := int64(1015)
+	editor, err := _permissionSvc.CreateRole(ctx, CreateRoleParam{
+		RoleableType:     roleableType,
+		RoleableID:       roleableID,
+		Name:             "editor",
+		Title:            "编辑",
+		PermissionGroups: []string{"app-post-manage"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := _permissionSvc.AssignRolesToUser(ctx, AssignRolesToUserParam{
+		UserID:       1,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+		RoleIDs:      []int64{editor.ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		param BuildUserPermissionGroupTreeParam
+		want  []*PermissionGroupItem
+	}{
+		{
+			name:  "ancestor not granted",
+			param: BuildUserPermissionGroupTreeParam{UserID: 1, RoleableType: roleableType, RoleableID: roleableID},
+			want: []*PermissionGroupItem{
+				{Name: "app-manage", Title: "应用管理", PermissionGroups: []*PermissionGroupItem{
+					{Name: "app-post-manage", Title: "应用管理", Granted: true},
+				}},
+			},
+		},
+		{
+			name:  "with permissions",
+			param: BuildUserPermissionGroupTreeParam{UserID: 1, RoleableType: roleableType, RoleableID: roleableID, WithPermissions: true},
+			want: []*PermissionGroupItem{
+				{Name: "app-manage", Title: "应用管理", PermissionGroups: []*PermissionGroupItem{
+					{Name: "app-post-manage", Title: "应用管理", Granted: true, Permissions: []string{
+						"app-posts-delete", "app-posts-get", "app-posts-list-get", "app-posts-post", "app-posts-put",
+					}},
+				}},
+			},
+		},
+		{
+			name:  "no roles",
+			param: BuildUserPermissionGroupTreeParam{UserID: 2, RoleableType: roleableType, RoleableID: roleableID},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := _permissionSvc.BuildUserPermissionGroupTree(ctx, tt.param)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PermissionService.BuildUserPermissionGroupTree() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissionService_BuildPermissionGroupTree(t *testing.T) {
+	permissionGroups := []*PermissionGroup{
+		{Name: "c", Title: "C", GroupIndex: 2, ParentName: "a"},
+		{Name: "b", Title: "B", GroupIndex: 1},
+		{Name: "a", Title: "A", GroupIndex: 0},
+		{Name: "d", Title: "D", GroupIndex: 1, ParentName: "a"},
+		{Name: "e", Title: "E", GroupIndex: 0, ParentName: "missing"},
+	}
+	permissionGroupPermissions := []*PermissionGroupPermission{
+		{PermissionGroupName: "a", PermissionName: "a-get"},
+		{PermissionGroupName: "c", PermissionName: "c-get"},
+		{PermissionGroupName: "c", PermissionName: "c-post"},
+	}
+
+	got := _permissionSvc.BuildPermissionGroupTreeWithPermissions(permissionGroups, permissionGroupPermissions, "")
+	want := []*PermissionGroupItem{
+		{Name: "a", Title: "A", Permissions: []string{"a-get"}, PermissionGroups: []*PermissionGroupItem{
+			{Name: "d", Title: "D"},
+			{Name: "c", Title: "C", Permissions: []string{"c-get", "c-post"}},
+		}},
+		{Name: "b", Title: "B"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PermissionService.BuildPermissionGroupTreeWithPermissions() = %v, want %v", got, want)
+	}
+
+	got = _permissionSvc.BuildPermissionGroupTree(permissionGroups, "a")
+	want = []*PermissionGroupItem{{Name: "d", Title: "D"}, {Name: "c", Title: "C"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PermissionService.BuildPermissionGroupTree() = %v, want %v", got, want)
+	}
+
+	got, err := _permissionSvc.BuildFullPermissionGroupTreeWithPermissions(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || len(got[0].Permissions) != 5 || len(got[0].PermissionGroups) != 1 || len(got[0].PermissionGroups[0].Permissions) != 5 {
+		t.Errorf("PermissionService.BuildFullPermissionGroupTreeWithPermissions() = %v", got)
+	}
+}
+
+func TestPermissionService_Localize(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "i18n.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := &PermissionMetadata{
+		Permissions: []*PermissionItem{
+			{Name: "apps-get", Title: "获取应用", Description: "获取应用详情", Resource: "/api/v1/apps", Action: "GET", Titles: map[string]string{"en": "Get apps"}, Descriptions: map[string]string{"en": "Get app details"}},
+		},
+		PermissionGroups: []*PermissionGroupItem{
+			{
+				Name:         "app-manage",
+				Title:        "应用管理",
+				Description:  "管理应用设置",
+				Permissions:  []string{"apps-get"},
+				Titles:       map[string]string{"en": "App management", "ja-JP": "アプリ管理"},
+				Descriptions: map[string]string{"en": "Manage app settings"},
+			},
+		},
+		Roles: []*RolePermissionGroupItem{
+			{
+				RoleableType:     "app",
+				Name:             "admin",
+				Title:            "管理员",
+				Description:      "管理应用",
+				PermissionGroups: []string{"app-manage"},
+				Titles:           map[string]string{"en": "Administrator", "ja": "管理者"},
+				Descriptions:     map[string]string{"en": "Manage apps"},
+			},
+		},
+	}
+	if err := metadata.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	svc := New(db, metadata, WithFallbackLocales("en"))
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPresetRoles(db, 1, "app"); err != nil {
+		t.Fatal(err)
+	}
+
+	treeTests := []struct {
+		locale          string
+		want            string
+		wantDescription string
+	}{
+		{locale: "", want: "应用管理", wantDescription: "管理应用设置"},
+		{locale: "ja_JP", want: "アプリ管理", wantDescription: "Manage app settings"},
+		{locale: "en-US", want: "App management", wantDescription: "Manage app settings"},
+		{locale: "fr", want: "App management", wantDescription: "Manage app settings"},
+	}
+	for _, tt := range treeTests {
+		tree, err := svc.BuildFullPermissionGroupTree(ctx, "", tt.locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree[0].Title != tt.want || tree[0].Description != tt.wantDescription {
+			t.Errorf("BuildFullPermissionGroupTree() locale %q = %s %s, want %s %s", tt.locale, tree[0].Title, tree[0].Description, tt.want, tt.wantDescription)
+		}
+		tree, err = svc.BuildFullPermissionGroupTreeWithPermissions(ctx, "", tt.locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree[0].Title != tt.want || !reflect.DeepEqual(tree[0].Permissions, []string{"apps-get"}) {
+			t.Errorf("BuildFullPermissionGroupTreeWithPermissions() locale %q = %s %v, want %s [apps-get]", tt.locale, tree[0].Title, tree[0].Permissions, tt.want)
+		}
+	}
+
+	roles, err := svc.GetRoles(ctx, 1, "app", "ja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role := roles[0]; role.Title != "管理者" || role.Description != "Manage apps" {
+		t.Errorf("GetRoles() localized role = %s %s, want 管理者 Manage apps", role.Title, role.Description)
+	}
+	if err := svc.AssignRolesToUser(ctx, AssignRolesToUserParam{UserID: 1, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{roles[0].ID}}); err != nil {
+		t.Fatal(err)
+	}
+	userRoles, err := svc.GetUserRoles(ctx, 1, 1, "app", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role := userRoles[0]; role.Title != "Administrator" || role.Description != "Manage apps" {
+		t.Errorf("GetUserRoles() localized role = %s %s, want Administrator Manage apps", role.Title, role.Description)
+	}
+	if roles, err = svc.GetRoles(ctx, 1, "app"); err != nil || roles[0].Title != "管理员" {
+		t.Errorf("GetRoles() without locale = %v, %v, want 管理员", roles, err)
+	}
+
+	result, err := svc.QueryRoles(ctx, QueryRolesParam{RoleableType: "app", RoleableID: 1, Locale: "ja"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role := result.Roles[0]; role.Title != "管理者" || role.Description != "Manage apps" {
+		t.Errorf("QueryRoles() localized role = %s %s, want 管理者 Manage apps", role.Title, role.Description)
+	}
+	if _, err := svc.UpdateRole(ctx, UpdateRoleParam{ID: result.Roles[0].ID, Title: "超级管理员", Description: "管理应用", PermissionGroups: []string{"app-manage"}}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = svc.QueryRoles(ctx, QueryRolesParam{RoleableType: "app", RoleableID: 1, Locale: "en"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role := result.Roles[0]; role.Title != "超级管理员" || role.Description != "Manage apps" {
+		t.Errorf("QueryRoles() customized role = %s %s, want 超级管理员 Manage apps", role.Title, role.Description)
+	}
+
+	exported, err := svc.ExportPermissionMetadata(ctx, ExportPermissionMetadataParam{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"en": "App management", "ja-jp": "アプリ管理"}; !reflect.DeepEqual(exported.PermissionGroups[0].Titles, want) {
+		t.Errorf("ExportPermissionMetadata() permission group titles = %v, want %v", exported.PermissionGroups[0].Titles, want)
+	}
+	if g := exported.PermissionGroups[0]; g.Description != "管理应用设置" || !reflect.DeepEqual(g.Descriptions, map[string]string{"en": "Manage app settings"}) {
+		t.Errorf("ExportPermissionMetadata() permission group descriptions = %s %v", g.Description, g.Descriptions)
+	}
+	if p := exported.Permissions[0]; p.Description != "获取应用详情" || !reflect.DeepEqual(p.Descriptions, map[string]string{"en": "Get app details"}) {
+		t.Errorf("ExportPermissionMetadata() permission descriptions = %s %v", p.Description, p.Descriptions)
+	}
+	plan, err := svc.PlanSyncPermissionMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.IsEmpty() {
+		t.Errorf("PlanSyncPermissionMetadata() after sync = %+v, want empty plan", plan)
+	}
+	metadata.PermissionGroups[0].Description = "应用设置"
+	if plan, err = svc.PlanSyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plan.UpdatePermissionGroups, []string{"app-manage"}) {
+		t.Errorf("PlanSyncPermissionMetadata() update permission groups = %v, want [app-manage]", plan.UpdatePermissionGroups)
+	}
+
+	metadata.Permissions[0].Titles["EN"] = "Get apps"
+	if err := metadata.Validate(); err == nil {
+		t.Errorf("PermissionMetadata.Validate() reduplicated locale error = nil")
+	}
+}
+
+func TestDiffRoutePermissions(t *testing.T) {
+	mux := NewRecordingServeMux()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	mux.HandleFunc("GET /api/v1/apps", handler)
+	mux.HandleFunc("GET /api/v1/apps/{id}", handler)
+	mux.HandleFunc("DELETE /api/v1/apps/{id}/posts/{postID}", handler)
+
+	permissions, err := PermissionsFromRoutes(mux.Patterns(), RoutePermissionsParam{PathPrefix: "/api/v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*PermissionItem{
+		{Name: "apps-list-get", Title: "GET /api/v1/apps", Resource: "/api/v1/apps", Action: "GET"},
+		{Name: "apps-get", Title: "GET /api/v1/apps/{id}", Resource: "/api/v1/apps/{id}", Action: "GET"},
+		{Name: "apps-posts-delete", Title: "DELETE /api/v1/apps/{id}/posts/{postID}", Resource: "/api/v1/apps/{id}/posts/{postID}", Action: "DELETE"},
+	}
+	if !reflect.DeepEqual(permissions, want) {
+		t.Errorf("PermissionsFromRoutes() = %v, want %v", permissions, want)
+	}
+
+	metadata := &PermissionMetadata{
+		Permissions: []*PermissionItem{
+			{Name: "apps-list-get", Resource: "/api/v1/apps", Action: "GET"},
+			{Name: "apps-post", Resource: "/api/v1/apps", Action: "POST"},
+			{Name: "team-get", Domain: "team", Resource: "/api/v1/teams/{id}", Action: "GET"},
+		},
+	}
+	diff, err := DiffRoutePermissions(metadata, mux.Patterns(), RoutePermissionsParam{PathPrefix: "/api/v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := diff.RoutesWithoutPermission; len(got) != 2 || got[0].Name != "apps-get" || got[1].Name != "apps-posts-delete" {
+		t.Errorf("DiffRoutePermissions() RoutesWithoutPermission = %v", got)
+	}
+	if got := diff.PermissionsWithoutRoute; len(got) != 1 || got[0].Name != "apps-post" {
+		t.Errorf("DiffRoutePermissions() PermissionsWithoutRoute = %v", got)
+	}
+
+	if _, err := PermissionsFromRoutes([]string{"GET /apps/{id}", "GET /apps/{name}/"}, RoutePermissionsParam{}); err == nil {
+		t.Errorf("PermissionsFromRoutes() reduplicated name error = nil")
+	}
+
+	// 元数据使用 :id 写法，路由使用 {id} 写法
+	patterns := []string{
+		"GET /api/v1/apps",
+		"POST /api/v1/apps",
+		"POST /api/v1/apps/{id}",
+		"PUT /api/v1/apps/{id}",
+		"DELETE /api/v1/apps/{id}",
+		"GET /api/v1/apps/{id}/posts",
+		"POST /api/v1/apps/{id}/posts",
+		"POST /api/v1/apps/{id}/posts/{postID}",
+		"PUT /api/v1/apps/{id}/posts/{postID}",
+		"DELETE /api/v1/apps/{id}/posts/{postID...}",
+	}
+	nameFunc := func(route *Route) string {
+		return route.Pattern
+	}
+	diff, err = DiffRoutePermissions(_permissionSvc.metadata, patterns, RoutePermissionsParam{NameFunc: nameFunc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.IsEmpty() {
+		t.Errorf("DiffRoutePermissions() with examples/metadata.yaml = %+v, want empty", diff)
+	}
+	diff, err = DiffRoutePermissions(_permissionSvc.metadata, patterns[1:], RoutePermissionsParam{NameFunc: nameFunc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := diff.PermissionsWithoutRoute; len(diff.RoutesWithoutPermission) != 0 || len(got) != 1 || got[0].Name != "apps-list-get" {
+		t.Errorf("DiffRoutePermissions() with examples/metadata.yaml = %+v", diff)
+	}
+}
+
+func TestNormalizeResourcePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/api/v1/apps", want: "/api/v1/apps"},
+		{path: "/api/v1/apps/{id}", want: "/api/v1/apps/{}"},
+		{path: "/api/v1/apps/:id", want: "/api/v1/apps/{}"},
+		{path: "/api/v1/apps/{id}/files/{path...}", want: "/api/v1/apps/{}/files/{}"},
+		{path: "/api/v1/apps/:id/files/*path", want: "/api/v1/apps/{}/files/{}"},
+		{path: "/api/v1/apps/{$}", want: "/api/v1/apps/{$}"},
+	}
+	for _, tt := range tests {
+		if got := normalizeResourcePath(tt.path); got != tt.want {
+			t.Errorf("normalizeResourcePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestImportOpenAPI(t *testing.T) {
+	spec := `
+openapi: 3.0.3
+tags:
+  - name: apps
+    description: 应用管理
+paths:
+  /apps:
+    parameters:
+      - name: page
+        in: query
+    get:
+      operationId: listApps
+      summary: 应用列表
+      tags: [apps]
+    post:
+      operationId: createApp
+      summary: 创建应用
+      tags: [apps]
+  /apps/{id}:
+    delete:
+      tags: [apps, danger]
+`
+	imported, err := ImportOpenAPI(strings.NewReader(spec), ImportOpenAPIParam{ResourcePrefix: "/api/v1", TagsAsGroups: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPermissions := []*PermissionItem{
+		{Name: "listApps", Title: "应用列表", Resource: "/api/v1/apps", Action: "GET"},
+		{Name: "createApp", Title: "创建应用", Resource: "/api/v1/apps", Action: "POST"},
+		{Name: "apps-delete", Title: "DELETE /apps/{id}", Resource: "/api/v1/apps/{id}", Action: "DELETE"},
+	}
+	if !reflect.DeepEqual(imported.Permissions, wantPermissions) {
+		t.Errorf("ImportOpenAPI() permissions = %v, want %v", imported.Permissions, wantPermissions)
+	}
+	wantGroups := []*PermissionGroupItem{
+		{Name: "apps", Title: "应用管理", Permissions: []string{"listApps", "createApp", "apps-delete"}},
+		{Name: "danger", Title: "danger", Permissions: []string{"apps-delete"}},
+	}
+	if !reflect.DeepEqual(imported.PermissionGroups, wantGroups) {
+		t.Errorf("ImportOpenAPI() permission groups = %v, want %v", imported.PermissionGroups, wantGroups)
+	}
+
+	// 手写的元数据使用 :id 写法，导入的权限使用 {id} 写法
+	base := &PermissionMetadata{
+		Permissions: []*PermissionItem{
+			{Name: "listApps", Title: "查看应用", Resource: "/api/v1/apps", Action: "GET"},
+			{Name: "apps-remove", Title: "删除应用", Resource: "/api/v1/apps/:id", Action: "DELETE"},
+		},
+		PermissionGroups: []*PermissionGroupItem{
+			{Name: "app-manage", Title: "应用管理", PermissionGroups: []*PermissionGroupItem{
+				{Name: "apps", Title: "应用", Permissions: []string{"apps-remove"}},
+			}},
+		},
+	}
+	merged := MergePermissionMetadata(base, imported)
+	if got := merged.Permissions; len(got) != 3 || got[0].Title != "查看应用" || got[1].Name != "apps-remove" || got[2].Name != "createApp" {
+		t.Errorf("MergePermissionMetadata() permissions = %v", got)
+	}
+	apps := merged.PermissionGroups[0].PermissionGroups[0]
+	if apps.Title != "应用" || !reflect.DeepEqual(apps.Permissions, []string{"apps-remove", "createApp"}) {
+		t.Errorf("MergePermissionMetadata() apps group = %v", apps)
+	}
+	if got := merged.PermissionGroups; len(got) != 2 || got[1].Name != "danger" || !reflect.DeepEqual(got[1].Permissions, []string{"apps-remove"}) {
+		t.Errorf("MergePermissionMetadata() permission groups = %v", got)
+	}
+	if len(base.PermissionGroups[0].PermissionGroups[0].Permissions) != 1 {
+		t.Errorf("MergePermissionMetadata() modified base metadata")
+	}
+
+	if _, err := ImportOpenAPI(strings.NewReader(`{"swagger": "2.0", "paths": {}}`), ImportOpenAPIParam{}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("ImportOpenAPI() swagger 2.0 error = %v, want %v", err, ErrInvalidArgument)
+	}
+	if _, err := ImportOpenAPI(strings.NewReader(`{"openapi": "3.1.0", "paths": {"/a": {"get": {"operationId": "a"}}, "/b": {"get": {"operationId": "a"}}}}`), ImportOpenAPIParam{}); err == nil {
+		t.Errorf("ImportOpenAPI() reduplicated operationId error = nil")
+	}
+}
+
+type otelHistogramRecord struct {
+	value float64
+	attrs []OTelAttribute
+}
+
+type otelHistogramFunc func(ctx context.Context, value float64, attrs ...OTelAttribute)
+
+func (f otelHistogramFunc) Record(ctx context.Context, value float64, attrs ...OTelAttribute) {
+	f(ctx, value, attrs...)
+}
+
+func TestPermissionService_Observers(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "observe.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prometheus := NewPrometheusObserver(0.5, 0.1)
+	var records []otelHistogramRecord
+	svc := New(db, _permissionSvc.metadata,
+		WithObserver(prometheus),
+		WithObserver(NewOTelObserver(otelHistogramFunc(func(ctx context.Context, value float64, attrs ...OTelAttribute) {
+			records = append(records, otelHistogramRecord{value: value, attrs: attrs})
+		}))),
+	)
+	if err := svc.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPermissionMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SyncPresetRoles(db, 1, "app"); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := svc.GetRoles(ctx, 1, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	param := HasPermissionParam{UserID: 1, RoleableType: "app", RoleableID: 1, Resource: "/api/v1/apps", Action: "GET"}
+	if ok, err := svc.HasPermission(ctx, param); err != nil || ok {
+		t.Fatalf("HasPermission() = %v, %v, want false", ok, err)
+	}
+	if err := svc.AddUserRoles(ctx, ChangeUserRolesParam{UserID: 1, RoleableType: "app", RoleableID: 1, RoleIDs: []int64{roles[0].ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := svc.HasPermission(ctx, param); err != nil || !ok {
+		t.Fatalf("HasPermission() = %v, %v, want true", ok, err)
+	}
+	if err := svc.DeleteRole(ctx, -1); !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("DeleteRole() error = %v, want %v", err, ErrRoleNotFound)
+	}
+	if explanation, err := svc.Explain(ctx, param); err != nil || !explanation.Allowed {
+		t.Fatalf("Explain() = %+v, %v, want allowed", explanation, err)
+	}
+	deniedParam := param
+	deniedParam.UserID = 2
+	if explanation, err := svc.Explain(ctx, deniedParam); err != nil || explanation.Allowed {
+		t.Fatalf("Explain() = %+v, %v, want denied", explanation, err)
+	}
+	if _, err := svc.HasPermissionGroups(ctx, HasPermissionGroupsParam{UserID: 1, RoleableType: "app", RoleableID: 1, PermissionGroupNames: []string{"app-manage"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.HasPermissionGroups(ctx, HasPermissionGroupsParam{UserID: 1, RoleableType: "app", RoleableID: 1, PermissionGroupNames: []string{"app-manage", "not-granted"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		operation string
+		result    string
+		want      uint64
+	}{
+		{"SyncPermissionMetadata", ObservationResultOK, 1},
+		{"SyncPresetRoles", ObservationResultOK, 1},
+		{"HasPermission", ObservationResultDenied, 1},
+		{"HasPermission", ObservationResultAllowed, 1},
+		{"AddUserRoles", ObservationResultOK, 1},
+		{"DeleteRole", ObservationResultError, 1},
+		{"Explain", ObservationResultAllowed, 1},
+		{"Explain", ObservationResultDenied, 1},
+		{"HasPermissionGroups", ObservationResultAllowed, 1},
+		{"HasPermissionGroups", ObservationResultDenied, 1},
+		{"HasPermissionGroups", ObservationResultOK, 0},
+		{"GetRoles", ObservationResultOK, 0},
+	} {
+		if got := prometheus.Count(tt.operation, tt.result); got != tt.want {
+			t.Errorf("PrometheusObserver.Count(%s, %s) = %d, want %d", tt.operation, tt.result, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := prometheus.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`permission_operations_total{operation="HasPermission",result="allowed"} 1`,
+		`permission_operation_duration_seconds_bucket{operation="HasPermission",le="0.1"} 2`,
+		`permission_operation_duration_seconds_bucket{operation="HasPermission",le="+Inf"} 2`,
+		`permission_operation_duration_seconds_count{operation="HasPermission"} 2`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("PrometheusObserver.WriteTo() missing %s in\n%s", want, buf.String())
+		}
+	}
+
+	if len(records) != 10 {
+		t.Fatalf("OTelObserver records = %v, want 10", records)
+	}
+	wantAttrs := []OTelAttribute{
+		{Key: OTelAttributeOperation, Value: "DeleteRole"},
+		{Key: OTelAttributeResult, Value: ObservationResultError},
+		{Key: OTelAttributeErrorType, Value: "role_not_found"},
+	}
+	if got := records[5].attrs; !reflect.DeepEqual(got, wantAttrs) {
+		t.Errorf("OTelObserver attrs = %v, want %v", got, wantAttrs)
+	}
+}
+
+func mustGetRoles(t *testing.T, roleableID int64, roleableType string) []*Role {
+	t.Helper()
+	roles, err := _permissionSvc.GetRoles(context.Background(), roleableID, roleableType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) == 0 {
+		t.Fatalf("no roles in %s:%d", roleableType, roleableID)
+	}
+	return roles
+}
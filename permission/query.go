@@ -0,0 +1,247 @@
+package permission
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 角色类型筛选
+const (
+	RoleKindPreset = "preset" // 元数据中定义的预置角色
+	RoleKindCustom = "custom" // 用户自定义角色
+)
+
+// 角色列表排序字段
+const (
+	RoleOrderByID          = "id"
+	RoleOrderByName        = "name"
+	RoleOrderByTitle       = "title"
+	RoleOrderByCreatedAt   = "created_at"
+	RoleOrderByUpdatedAt   = "updated_at"
+	RoleOrderByMemberCount = "member_count"
+)
+
+const (
+	defaultQueryRolesLimit = 20
+	maxQueryRolesLimit     = 1000
+)
+
+type QueryRolesParam struct {
+	RoleableType  string `json:"roleable_type" yaml:"roleable_type"`
+	RoleableID    int64  `json:"roleable_id" yaml:"roleable_id"`
+	UserID        int64  `json:"user_id" yaml:"user_id"`                 // 不为 0 时只返回用户拥有的角色
+	Keyword       string `json:"keyword" yaml:"keyword"`                 // 模糊匹配 name, title, description
+	Kind          string `json:"kind" yaml:"kind"`                       // preset | custom，为空时不筛选
+	CreatorUserID int64  `json:"creator_user_id" yaml:"creator_user_id"` // 不为 0 时按创建者筛选
+	OrderBy       string `json:"order_by" yaml:"order_by"`               // 默认按 id 排序
+	Desc          bool   `json:"desc" yaml:"desc"`
+	Limit         int    `json:"limit" yaml:"limit"`   // 默认 20，最大 1000
+	Offset        int    `json:"offset" yaml:"offset"` // 偏移分页，和 Cursor 同时指定时以 Cursor 为准
+	Cursor        string `json:"cursor" yaml:"cursor"` // 游标分页，使用上一页返回的 NextCursor
+	Locale        string `json:"locale" yaml:"locale"` // 预置角色标题和描述的语言，为空时使用原值，回退规则见 WithFallbackLocales
+}
+
+// 角色列表项
+type RoleListItem struct {
+	Role
+	MemberCount int64 `json:"member_count" yaml:"member_count"` // 拥有该角色的用户数
+	Preset      bool  `json:"preset" yaml:"preset" gorm:"-"`    // 是否为预置角色
+}
+
+type QueryRolesResult struct {
+	Roles      []*RoleListItem `json:"roles" yaml:"roles"`
+	Total      int64           `json:"total" yaml:"total"`             // 符合筛选条件的角色总数，不受分页影响
+	NextCursor string          `json:"next_cursor" yaml:"next_cursor"` // 为空代表没有下一页
+}
+
+// 游标内容，记录上一页最后一个角色的排序字段值和 id
+type queryRolesCursor struct {
+	Value json.RawMessage `json:"v"`
+	ID    int64           `json:"id"`
+}
+
+// 分页查询角色列表，支持搜索、筛选和排序，成员数在数据库中计算
+func (s *PermissionService) QueryRoles(ctx context.Context, param QueryRolesParam) (*QueryRolesResult, error) {
+	roleTableName := s.cachedTableNames.roleTableName
+	userRoleTableName := s.cachedTableNames.userRoleTableName
+	memberCountExpr := fmt.Sprintf("(SELECT COUNT(1) FROM %s WHERE %s.role_id = %s.id)", userRoleTableName, userRoleTableName, roleTableName)
+
+	orderExpr := param.OrderBy
+	switch param.OrderBy {
+	case "":
+		param.OrderBy = RoleOrderByID
+		orderExpr = RoleOrderByID
+	case RoleOrderByID, RoleOrderByName, RoleOrderByTitle, RoleOrderByCreatedAt, RoleOrderByUpdatedAt:
+	case RoleOrderByMemberCount:
+		orderExpr = memberCountExpr
+	default:
+		return nil, fmt.Errorf("%w: unsupported role order by %s", ErrInvalidArgument, param.OrderBy)
+	}
+	switch param.Kind {
+	case "", RoleKindPreset, RoleKindCustom:
+	default:
+		return nil, fmt.Errorf("%w: unsupported role kind %s", ErrInvalidArgument, param.Kind)
+	}
+	if param.Limit <= 0 {
+		param.Limit = defaultQueryRolesLimit
+	}
+	if param.Limit > maxQueryRolesLimit {
+		param.Limit = maxQueryRolesLimit
+	}
+
+	presetRoleNames := s.presetRoleNames(param.RoleableType)
+	result := &QueryRolesResult{Roles: []*RoleListItem{}}
+	if param.Kind == RoleKindPreset && len(presetRoleNames) == 0 {
+		return result, nil
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		db = db.Scopes(notDeletedRole).Where("roleable_type = ?", param.RoleableType).Where("roleable_id = ?", param.RoleableID)
+		if param.UserID != 0 {
+			db = db.Where("id IN (?)", s.db.Model(&UserRole{}).Select("role_id").Where("user_id = ?", param.UserID))
+		}
+		if param.Keyword != "" {
+			keyword := "%" + escapeLike(strings.ToLower(param.Keyword)) + "%"
+			db = db.Where("(LOWER(name) LIKE ? ESCAPE '!' OR LOWER(title) LIKE ? ESCAPE '!' OR LOWER(description) LIKE ? ESCAPE '!')", keyword, keyword, keyword)
+		}
+		switch param.Kind {
+		case RoleKindPreset:
+			db = db.Where("name IN ?", presetRoleNames)
+		case RoleKindCustom:
+			if len(presetRoleNames) > 0 {
+				db = db.Where("name NOT IN ?", presetRoleNames)
+			}
+		}
+		if param.CreatorUserID != 0 {
+			db = db.Where("creator_user_id = ?", param.CreatorUserID)
+		}
+		return db
+	}
+
+	db := s.db.WithContext(ctx)
+	if err := db.Model(&Role{}).Scopes(filter).Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+
+	order := "ASC"
+	cmp := ">"
+	if param.Desc {
+		order = "DESC"
+		cmp = "<"
+	}
+	query := db.Model(&Role{}).Scopes(filter).
+		Select(fmt.Sprintf("%s.*, %s AS member_count", roleTableName, memberCountExpr)).
+		Order(fmt.Sprintf("%s %s, id %s", orderExpr, order, order)).
+		Limit(param.Limit + 1)
+	if param.Cursor != "" {
+		value, id, err := decodeQueryRolesCursor(param.Cursor, param.OrderBy)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", orderExpr, cmp, orderExpr, cmp), value, value, id)
+	} else if param.Offset > 0 {
+		query = query.Offset(param.Offset)
+	}
+	var items []*RoleListItem
+	if err := query.Scan(&items).Error; err != nil {
+		return nil, err
+	}
+
+	if len(items) > param.Limit {
+		items = items[:param.Limit]
+		cursor, err := encodeQueryRolesCursor(items[len(items)-1], param.OrderBy)
+		if err != nil {
+			return nil, err
+		}
+		result.NextCursor = cursor
+	}
+
+	presetRoleNamesMap := make(map[string]struct{}, len(presetRoleNames))
+	for _, name := range presetRoleNames {
+		presetRoleNamesMap[name] = struct{}{}
+	}
+	roles := make([]*Role, 0, len(items))
+	for _, item := range items {
+		_, item.Preset = presetRoleNamesMap[item.Name]
+		roles = append(roles, &item.Role)
+	}
+	if err := s.LocalizeRoles(ctx, roles, param.Locale); err != nil {
+		return nil, err
+	}
+	result.Roles = append(result.Roles, items...)
+	return result, nil
+}
+
+// 元数据中某个角色类型的预置角色 name 列表
+func (s *PermissionService) presetRoleNames(roleableType string) []string {
+	var names []string
+	for _, r := range s.metadata.Roles {
+		if r.RoleableType == roleableType {
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+// 转义 LIKE 通配符，配合 ESCAPE '!' 使用
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+func encodeQueryRolesCursor(item *RoleListItem, orderBy string) (string, error) {
+	var value any
+	switch orderBy {
+	case RoleOrderByID:
+		value = item.ID
+	case RoleOrderByName:
+		value = item.Name
+	case RoleOrderByTitle:
+		value = item.Title
+	case RoleOrderByCreatedAt:
+		value = item.CreatedAt
+	case RoleOrderByUpdatedAt:
+		value = item.UpdatedAt
+	case RoleOrderByMemberCount:
+		value = item.MemberCount
+	}
+	rawValue, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(queryRolesCursor{Value: rawValue, ID: item.ID})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(content), nil
+}
+
+func decodeQueryRolesCursor(cursor string, orderBy string) (any, int64, error) {
+	content, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: invalid cursor: %v", ErrInvalidArgument, err)
+	}
+	var c queryRolesCursor
+	if err := json.Unmarshal(content, &c); err != nil {
+		return nil, 0, fmt.Errorf("%w: invalid cursor: %v", ErrInvalidArgument, err)
+	}
+	switch orderBy {
+	case RoleOrderByName, RoleOrderByTitle:
+		var value string
+		if err := json.Unmarshal(c.Value, &value); err != nil {
+			return nil, 0, fmt.Errorf("%w: invalid cursor: %v", ErrInvalidArgument, err)
+		}
+		return value, c.ID, nil
+	default:
+		value, err := strconv.ParseInt(string(c.Value), 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: invalid cursor: %v", ErrInvalidArgument, err)
+		}
+		return value, c.ID, nil
+	}
+}
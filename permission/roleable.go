@@ -0,0 +1,170 @@
+package permission
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 对象回收时每批检查的对象数量
+const defaultGCBatchSize = 500
+
+// 删除对象下的全部角色、角色权限组和用户角色，包括已软删除的角色，比如应用被删除，返回删除的角色数量
+//
+// 为未删除的角色发出 role.deleted 事件，为拥有角色的用户发出 user_roles.changed 事件，不受持有人数约束限制
+func (s *PermissionService) DeleteRoleable(ctx context.Context, roleableType string, roleableID int64) (_ int64, err error) {
+	defer s.observe(ctx, "DeleteRoleable", time.Now(), &err)
+	var count int64
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		var roleIDs []int64
+		if err := tx.Model(&Role{}).
+			Where("roleable_type = ?", roleableType).
+			Where("roleable_id = ?", roleableID).
+			Pluck("id", &roleIDs).Error; err != nil {
+			return err
+		}
+		if len(roleIDs) == 0 {
+			return nil
+		}
+
+		var roleSnapshots []*RoleSnapshot
+		var userRolesSnapshots []*UserRolesSnapshot
+		if s.hasHooks() {
+			var err error
+			if roleSnapshots, userRolesSnapshots, err = s.roleableSnapshots(tx, roleableType, roleableID); err != nil {
+				return err
+			}
+		}
+
+		var userIDs []int64
+		if err := tx.Model(&UserRole{}).Distinct("user_id").Where("role_id IN ?", roleIDs).Order("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+			return err
+		}
+		if err := s.bumpUserRolesVersions(tx, roleableType, roleableID, userIDs...); err != nil {
+			return err
+		}
+
+		if err := tx.Where("role_id IN ?", roleIDs).Delete(&RolePermissionGroup{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id IN ?", roleIDs).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id IN ?", roleIDs).Delete(&Role{})
+		if result.Error != nil {
+			return result.Error
+		}
+		count = result.RowsAffected
+
+		for _, snapshot := range roleSnapshots {
+			if err := emit(&Event{Type: EventRoleDeleted, Before: snapshot}); err != nil {
+				return err
+			}
+		}
+		for _, snapshot := range userRolesSnapshots {
+			if err := s.emitUserRolesRemoved(emit, snapshot); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// 获取对象下未删除角色的快照，以及拥有这些角色的用户角色快照
+func (s *PermissionService) roleableSnapshots(tx *gorm.DB, roleableType string, roleableID int64) ([]*RoleSnapshot, []*UserRolesSnapshot, error) {
+	var roleIDs []int64
+	if err := tx.Model(&Role{}).
+		Scopes(notDeletedRole).
+		Where("roleable_type = ?", roleableType).
+		Where("roleable_id = ?", roleableID).
+		Order("id").Pluck("id", &roleIDs).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(roleIDs) == 0 {
+		return nil, nil, nil
+	}
+
+	roleSnapshots := make([]*RoleSnapshot, 0, len(roleIDs))
+	for _, roleID := range roleIDs {
+		snapshot, err := s.roleSnapshot(tx, roleID)
+		if err != nil {
+			return nil, nil, err
+		}
+		roleSnapshots = append(roleSnapshots, snapshot)
+	}
+
+	var userRoles []*UserRole
+	if err := tx.Where("role_id IN ?", roleIDs).Order("user_id, role_id").Find(&userRoles).Error; err != nil {
+		return nil, nil, err
+	}
+	var userRolesSnapshots []*UserRolesSnapshot
+	for _, ur := range userRoles {
+		if n := len(userRolesSnapshots); n > 0 && userRolesSnapshots[n-1].UserID == ur.UserID {
+			userRolesSnapshots[n-1].RoleIDs = append(userRolesSnapshots[n-1].RoleIDs, ur.RoleID)
+			continue
+		}
+		userRolesSnapshots = append(userRolesSnapshots, &UserRolesSnapshot{
+			UserID:       ur.UserID,
+			RoleableType: roleableType,
+			RoleableID:   roleableID,
+			RoleIDs:      []int64{ur.RoleID},
+		})
+	}
+	return roleSnapshots, userRolesSnapshots, nil
+}
+
+// 返回 roleableIDs 中仍然存在的对象ID，通常由业务方查询自己的对象表实现
+type RoleableExistsFunc func(ctx context.Context, roleableType string, roleableIDs []int64) ([]int64, error)
+
+// 回收已不存在的对象的角色数据，按 batchSize 分批通过 exists 检查拥有角色的对象是否仍然存在，
+// 不存在的对象逐个执行 DeleteRoleable，返回被回收的对象ID，batchSize 为 0 时使用 500
+func (s *PermissionService) GCRoleables(ctx context.Context, roleableType string, batchSize int, exists RoleableExistsFunc) (_ []int64, err error) {
+	defer s.observe(ctx, "GCRoleables", time.Now(), &err)
+	if batchSize <= 0 {
+		batchSize = defaultGCBatchSize
+	}
+
+	var deletedRoleableIDs []int64
+	var lastRoleableID *int64
+	for {
+		query := s.db.WithContext(ctx).Model(&Role{}).
+			Distinct("roleable_id").
+			Where("roleable_type = ?", roleableType)
+		if lastRoleableID != nil {
+			query = query.Where("roleable_id > ?", *lastRoleableID)
+		}
+		var roleableIDs []int64
+		if err := query.Order("roleable_id").Limit(batchSize).Pluck("roleable_id", &roleableIDs).Error; err != nil {
+			return deletedRoleableIDs, err
+		}
+		if len(roleableIDs) == 0 {
+			return deletedRoleableIDs, nil
+		}
+		lastRoleableID = &roleableIDs[len(roleableIDs)-1]
+
+		existedRoleableIDs, err := exists(ctx, roleableType, roleableIDs)
+		if err != nil {
+			return deletedRoleableIDs, err
+		}
+		existedRoleableIDsMap := make(map[int64]struct{}, len(existedRoleableIDs))
+		for _, roleableID := range existedRoleableIDs {
+			existedRoleableIDsMap[roleableID] = struct{}{}
+		}
+		for _, roleableID := range roleableIDs {
+			if _, ok := existedRoleableIDsMap[roleableID]; ok {
+				continue
+			}
+			if _, err := s.DeleteRoleable(ctx, roleableType, roleableID); err != nil {
+				return deletedRoleableIDs, err
+			}
+			deletedRoleableIDs = append(deletedRoleableIDs, roleableID)
+		}
+		if len(roleableIDs) < batchSize {
+			return deletedRoleableIDs, nil
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package permission
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// http.ServeMux 路由，Method 为空代表匹配所有方法
+type Route struct {
+	Pattern string `json:"pattern" yaml:"pattern"`
+	Method  string `json:"method" yaml:"method"`
+	Host    string `json:"host" yaml:"host"`
+	Path    string `json:"path" yaml:"path"`
+}
+
+// 解析 Go 1.22+ http.ServeMux 的路由模式，格式为 [METHOD ][HOST]/[PATH]，比如 GET /api/v1/apps/{id}
+func ParseServeMuxPattern(pattern string) (*Route, error) {
+	route := &Route{Pattern: pattern}
+	rest := strings.TrimSpace(pattern)
+	if method, path, ok := strings.Cut(rest, " "); ok {
+		route.Method = method
+		rest = strings.TrimLeft(path, " \t")
+	}
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("%w: route pattern %q has no path", ErrInvalidArgument, pattern)
+	}
+	route.Host, route.Path = rest[:i], rest[i:]
+	return route, nil
+}
+
+// 记录注册路由的 http.ServeMux，用于根据路由生成权限元数据
+type RecordingServeMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func NewRecordingServeMux() *RecordingServeMux {
+	return &RecordingServeMux{ServeMux: http.NewServeMux()}
+}
+
+func (m *RecordingServeMux) Handle(pattern string, handler http.Handler) {
+	m.ServeMux.Handle(pattern, handler)
+	m.patterns = append(m.patterns, pattern)
+}
+
+func (m *RecordingServeMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.ServeMux.HandleFunc(pattern, handler)
+	m.patterns = append(m.patterns, pattern)
+}
+
+// 已注册的路由模式，按注册顺序排列
+func (m *RecordingServeMux) Patterns() []string {
+	return append([]string(nil), m.patterns...)
+}
+
+type RoutePermissionsParam struct {
+	Domain     string                    `json:"domain" yaml:"domain"`           // 生成权限的 domain
+	PathPrefix string                    `json:"path_prefix" yaml:"path_prefix"` // 生成 name 时忽略的路径前缀，比如 /api/v1
+	NameFunc   func(route *Route) string `json:"-" yaml:"-"`                     // 自定义权限 name，为 nil 时使用 RoutePermissionName
+	DomainFunc func(route *Route) string `json:"-" yaml:"-"`                     // 自定义权限 domain，比如按路径前缀区分团队和应用，为 nil 时使用 Domain
+}
+
+// 根据路由生成的默认权限 name，使用去掉前缀和路径参数后的路径加上方法，
+// 比如 GET /api/v1/apps/{id}/posts 去掉 /api/v1 前缀后为 apps-posts-list-get，GET /api/v1/apps/{id} 为 apps-get
+func RoutePermissionName(route *Route, pathPrefix string) string {
+	path := strings.TrimPrefix(route.Path, strings.TrimSuffix(pathPrefix, "/"))
+	var parts []string
+	var lastWildcard bool
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "{$}" {
+			continue
+		}
+		lastWildcard = strings.HasPrefix(segment, "{")
+		if !lastWildcard {
+			parts = append(parts, strings.ToLower(segment))
+		}
+	}
+	method := strings.ToLower(route.Method)
+	if method == "" {
+		method = "any"
+	}
+	if method == "get" && !lastWildcard && len(parts) > 0 {
+		parts = append(parts, "list")
+	}
+	return strings.Join(append(parts, method), "-")
+}
+
+// 根据路由生成权限，Resource 为路由路径，Action 为路由方法，Title 为路由模式，需要后续补充
+//
+// 路径保持 http.ServeMux 的 {name} 写法，检查权限时可以直接使用 http.Request.Pattern 中的路径
+func PermissionsFromRoutes(patterns []string, param RoutePermissionsParam) ([]*PermissionItem, error) {
+	var errs []error
+	var permissions []*PermissionItem
+	namesMap := make(map[string]string, len(patterns))
+	for _, pattern := range patterns {
+		route, err := ParseServeMuxPattern(pattern)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var name string
+		if param.NameFunc != nil {
+			name = param.NameFunc(route)
+		} else {
+			name = RoutePermissionName(route, param.PathPrefix)
+		}
+		if existed, ok := namesMap[name]; ok {
+			errs = append(errs, fmt.Errorf("route %q and route %q both named %s", existed, pattern, name))
+			continue
+		}
+		namesMap[name] = pattern
+		domain := param.Domain
+		if param.DomainFunc != nil {
+			domain = param.DomainFunc(route)
+		}
+		permissions = append(permissions, &PermissionItem{
+			Name:     name,
+			Title:    pattern,
+			Domain:   domain,
+			Resource: route.Path,
+			Action:   route.Method,
+		})
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// 路由和权限元数据的差异
+type RoutePermissionDiff struct {
+	RoutesWithoutPermission []*PermissionItem `json:"routes_without_permission" yaml:"routes_without_permission"` // 没有对应权限的路由，可以直接补充到元数据
+	PermissionsWithoutRoute []*PermissionItem `json:"permissions_without_route" yaml:"permissions_without_route"` // 没有对应路由的权限
+}
+
+// 是否没有差异
+func (d *RoutePermissionDiff) IsEmpty() bool {
+	return len(d.RoutesWithoutPermission) == 0 && len(d.PermissionsWithoutRoute) == 0
+}
+
+// 按 domain, resource, action 对比路由和权限元数据，只对比路由生成的权限所在 domain 下的权限
+//
+// resource 中的路径参数统一后再对比，{id}、{id...} 和 :id 视为相同
+func DiffRoutePermissions(metadata *PermissionMetadata, patterns []string, param RoutePermissionsParam) (*RoutePermissionDiff, error) {
+	routePermissions, err := PermissionsFromRoutes(patterns, param)
+	if err != nil {
+		return nil, err
+	}
+
+	key := func(p *PermissionItem) string {
+		return fmt.Sprintf("%s_%s_%s", p.Domain, normalizeResourcePath(p.Resource), p.Action)
+	}
+	routeKeysMap := make(map[string]struct{}, len(routePermissions))
+	domainsMap := map[string]struct{}{param.Domain: {}}
+	for _, p := range routePermissions {
+		routeKeysMap[key(p)] = struct{}{}
+		domainsMap[p.Domain] = struct{}{}
+	}
+	permissionKeysMap := make(map[string]struct{}, len(metadata.Permissions))
+	diff := &RoutePermissionDiff{}
+	for _, p := range metadata.Permissions {
+		if _, ok := domainsMap[p.Domain]; !ok {
+			continue
+		}
+		permissionKeysMap[key(p)] = struct{}{}
+		if _, ok := routeKeysMap[key(p)]; !ok {
+			diff.PermissionsWithoutRoute = append(diff.PermissionsWithoutRoute, p)
+		}
+	}
+	for _, p := range routePermissions {
+		if _, ok := permissionKeysMap[key(p)]; !ok {
+			diff.RoutesWithoutPermission = append(diff.RoutesWithoutPermission, p)
+		}
+	}
+	return diff, nil
+}
+
+// 将路径参数统一为 {}，比如 /apps/{id}、/apps/{id...}、/apps/:id 和 /apps/*id 都转换为 /apps/{}，用于对比不同写法的资源路径
+func normalizeResourcePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "{$}" {
+			continue
+		}
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") ||
+			(strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")) {
+			segments[i] = "{}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,140 @@
+package permission
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 只查询未删除的角色
+func notDeletedRole(db *gorm.DB) *gorm.DB {
+	return db.Where("deleted_at = 0")
+}
+
+// 获取某个对象下已软删除的角色列表，按删除时间倒序
+func (s *PermissionService) GetDeletedRoles(ctx context.Context, roleableID int64, roleableType string) ([]*Role, error) {
+	var roles []*Role
+	if err := s.db.WithContext(ctx).Model(&Role{}).
+		Where("roleable_type = ?", roleableType).
+		Where("roleable_id = ?", roleableID).
+		Where("deleted_at > 0").
+		Order("deleted_at DESC").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// 恢复已软删除的角色，角色权限组和用户角色随之恢复生效
+func (s *PermissionService) RestoreRole(ctx context.Context, roleID int64) (_ *Role, err error) {
+	defer s.observe(ctx, "RestoreRole", time.Now(), &err)
+	return s.restoreRole(ctx, roleID, nil)
+}
+
+// check 在恢复前检查，为 nil 时不检查
+func (s *PermissionService) restoreRole(ctx context.Context, roleID int64, check func(tx *gorm.DB, role *Role) error) (*Role, error) {
+	var role Role
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		if err := tx.Where("id = ?", roleID).Where("deleted_at > 0").Limit(1).Find(&role).Error; err != nil {
+			return err
+		}
+		if role.ID == 0 {
+			return fmt.Errorf("%w: deleted role id %d", ErrRoleNotFound, roleID)
+		}
+		if check != nil {
+			if err := check(tx, &role); err != nil {
+				return err
+			}
+		}
+
+		var count int64
+		if err := tx.Model(&Role{}).
+			Scopes(notDeletedRole).
+			Where("roleable_type = ?", role.RoleableType).
+			Where("roleable_id = ?", role.RoleableID).
+			Where("name = ?", role.Name).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: role name %s existed in %s:%d", ErrRoleAlreadyExists, role.Name, role.RoleableType, role.RoleableID)
+		}
+
+		role.DeletedAt = 0
+		if err := tx.Model(&role).Update("deleted_at", 0).Error; err != nil {
+			return err
+		}
+		if err := s.bumpRoleUserRolesVersions(tx, &role); err != nil {
+			return err
+		}
+		if err := s.checkRoleConstraintsByRole(tx, &role); err != nil {
+			return err
+		}
+		if !s.hasHooks() {
+			return nil
+		}
+		after, err := s.roleSnapshot(tx, role.ID)
+		if err != nil {
+			return err
+		}
+		return emit(&Event{Type: EventRoleRestored, After: after})
+	}); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// 彻底删除软删除时间早于 retention 之前的角色，以及角色权限组和用户角色，返回删除的角色数
+func (s *PermissionService) PurgeDeletedRoles(ctx context.Context, retention time.Duration) (_ int64, err error) {
+	defer s.observe(ctx, "PurgeDeletedRoles", time.Now(), &err)
+	deletedBefore := time.Now().Add(-retention).UnixMilli()
+
+	var count int64
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var roleIDs []int64
+		if err := tx.Model(&Role{}).
+			Where("deleted_at > 0").
+			Where("deleted_at < ?", deletedBefore).
+			Pluck("id", &roleIDs).Error; err != nil {
+			return err
+		}
+		if len(roleIDs) == 0 {
+			return nil
+		}
+
+		if err := tx.Where("role_id IN ?", roleIDs).Delete(&RolePermissionGroup{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id IN ?", roleIDs).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id IN ?", roleIDs).Delete(&Role{})
+		if result.Error != nil {
+			return result.Error
+		}
+		count = result.RowsAffected
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// 按 interval 周期执行 PurgeDeletedRoles，直到 ctx 结束
+func (s *PermissionService) RunPurgeDeletedRoles(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PurgeDeletedRoles(ctx, retention); err != nil {
+			log.Println(fmt.Errorf("purge deleted roles err=%w", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package permission
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// 根据某个 domain 下所有权限组构造完整的权限树
+//
+// locale 可选，指定标题和描述的语言，为空时使用默认值，回退规则见 WithFallbackLocales
+func (s *PermissionService) BuildFullPermissionGroupTree(ctx context.Context, domain string, locale ...string) ([]*PermissionGroupItem, error) {
+	return s.buildFullPermissionGroupTree(ctx, domain, false, optionalLocale(locale))
+}
+
+// 根据某个 domain 下所有权限组构造完整的权限树，每个节点包含权限 name 列表，比如在角色编辑页面展示权限组覆盖的接口
+//
+// locale 可选，和 BuildFullPermissionGroupTree 相同
+func (s *PermissionService) BuildFullPermissionGroupTreeWithPermissions(ctx context.Context, domain string, locale ...string) ([]*PermissionGroupItem, error) {
+	return s.buildFullPermissionGroupTree(ctx, domain, true, optionalLocale(locale))
+}
+
+func (s *PermissionService) buildFullPermissionGroupTree(ctx context.Context, domain string, withPermissions bool, locale string) ([]*PermissionGroupItem, error) {
+	db := s.db.WithContext(ctx)
+	var permissionGroups []*PermissionGroup
+	if err := db.Model(&PermissionGroup{}).
+		Where("domain = ?", domain).
+		Order("group_index").
+		Find(&permissionGroups).Error; err != nil {
+		return nil, err
+	}
+	var permissionNamesMap map[string][]string
+	if withPermissions {
+		permissionGroupNames := make([]string, 0, len(permissionGroups))
+		for _, group := range permissionGroups {
+			permissionGroupNames = append(permissionGroupNames, group.Name)
+		}
+		var err error
+		if permissionNamesMap, err = getPermissionNamesMap(db, permissionGroupNames); err != nil {
+			return nil, err
+		}
+	}
+	tree := buildPermissionGroupTree(permissionGroups, "", permissionNamesMap)
+	if err := s.LocalizePermissionGroupTree(ctx, tree, locale); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// 根据权限组构造权限树，只包含 parentName 下的权限组，同级权限组按 GroupIndex 排序
+func (s *PermissionService) BuildPermissionGroupTree(permissionGroups []*PermissionGroup, parentName string) []*PermissionGroupItem {
+	return buildPermissionGroupTree(permissionGroups, parentName, nil)
+}
+
+// 根据权限组和权限组权限关系构造权限树，每个节点包含权限 name 列表
+func (s *PermissionService) BuildPermissionGroupTreeWithPermissions(permissionGroups []*PermissionGroup, permissionGroupPermissions []*PermissionGroupPermission, parentName string) []*PermissionGroupItem {
+	permissionNamesMap := make(map[string][]string)
+	for _, p := range permissionGroupPermissions {
+		permissionNamesMap[p.PermissionGroupName] = append(permissionNamesMap[p.PermissionGroupName], p.PermissionName)
+	}
+	return buildPermissionGroupTree(permissionGroups, parentName, permissionNamesMap)
+}
+
+// 按 ParentName 分组一次构造权限树，permissionNamesMap 为权限组 name 到权限 name 列表的映射
+func buildPermissionGroupTree(permissionGroups []*PermissionGroup, parentName string, permissionNamesMap map[string][]string) []*PermissionGroupItem {
+	sortedPermissionGroups := slices.Clone(permissionGroups)
+	slices.SortStableFunc(sortedPermissionGroups, func(a, b *PermissionGroup) int {
+		return cmp.Compare(a.GroupIndex, b.GroupIndex)
+	})
+
+	items := make([]*PermissionGroupItem, 0, len(sortedPermissionGroups))
+	childrenMap := make(map[string][]*PermissionGroupItem, len(sortedPermissionGroups))
+	for _, group := range sortedPermissionGroups {
+		item := &PermissionGroupItem{
+			Name:        group.Name,
+			Domain:      group.Domain,
+			Title:       group.Title,
+			Description: group.Description,
+			Permissions: permissionNamesMap[group.Name],
+		}
+		items = append(items, item)
+		childrenMap[group.ParentName] = append(childrenMap[group.ParentName], item)
+	}
+	for _, item := range items {
+		item.PermissionGroups = childrenMap[item.Name]
+	}
+	return childrenMap[parentName]
+}
+
+// 获取权限组 name 到权限 name 列表的映射，权限按 name 排序
+func getPermissionNamesMap(db *gorm.DB, permissionGroupNames []string) (map[string][]string, error) {
+	permissionNamesMap := make(map[string][]string, len(permissionGroupNames))
+	if len(permissionGroupNames) == 0 {
+		return permissionNamesMap, nil
+	}
+	var permissionGroupPermissions []*PermissionGroupPermission
+	if err := db.Model(&PermissionGroupPermission{}).
+		Where("permission_group_name IN ?", permissionGroupNames).
+		Order("permission_name").
+		Find(&permissionGroupPermissions).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range permissionGroupPermissions {
+		permissionNamesMap[p.PermissionGroupName] = append(permissionNamesMap[p.PermissionGroupName], p.PermissionName)
+	}
+	return permissionNamesMap, nil
+}
+
+type BuildUserPermissionGroupTreeParam struct {
+	UserID          int64  `json:"user_id" yaml:"user_id"`
+	RoleableType    string `json:"roleable_type" yaml:"roleable_type"`
+	RoleableID      int64  `json:"roleable_id" yaml:"roleable_id"`
+	Domain          string `json:"domain" yaml:"domain"`
+	WithPermissions bool   `json:"with_permissions" yaml:"with_permissions"` // 是否在用户拥有的权限组节点中包含权限 name 列表
+	Locale          string `json:"locale" yaml:"locale"`                     // 标题语言，为空时使用默认标题，回退规则见 WithFallbackLocales
+}
+
+// 根据用户在某个对象下拥有的权限组构造权限树，比如侧边栏菜单
+//
+// 只保留用户拥有的权限组及其祖先，祖先节点用于导航，Granted 为 false
+func (s *PermissionService) BuildUserPermissionGroupTree(ctx context.Context, param BuildUserPermissionGroupTreeParam) ([]*PermissionGroupItem, error) {
+	var permissionGroups []*PermissionGroup
+	if err := s.db.WithContext(ctx).Model(&PermissionGroup{}).
+		Where("domain = ?", param.Domain).
+		Order("group_index").
+		Find(&permissionGroups).Error; err != nil {
+		return nil, err
+	}
+
+	sql := fmt.Sprintf(`SELECT DISTINCT permission_group_name FROM %s WHERE role_id IN (
+		SELECT id FROM %s WHERE roleable_type = ? AND roleable_id = ? AND deleted_at = 0 AND id IN (
+			SELECT role_id FROM %s WHERE user_id = ?
+		)
+	)`,
+		s.cachedTableNames.rolePermissionGroupTableName,
+		s.cachedTableNames.roleTableName,
+		s.cachedTableNames.userRoleTableName)
+
+	var grantedPermissionGroupNames []string
+	if err := s.db.WithContext(ctx).Raw(sql, param.RoleableType, param.RoleableID, param.UserID).Scan(&grantedPermissionGroupNames).Error; err != nil {
+		return nil, err
+	}
+	grantedPermissionGroupNamesMap := make(map[string]struct{}, len(grantedPermissionGroupNames))
+	for _, name := range grantedPermissionGroupNames {
+		grantedPermissionGroupNamesMap[name] = struct{}{}
+	}
+
+	// 保留用户拥有的权限组及其祖先
+	parentNamesMap := make(map[string]string, len(permissionGroups))
+	for _, group := range permissionGroups {
+		parentNamesMap[group.Name] = group.ParentName
+	}
+	keptPermissionGroupNamesMap := make(map[string]struct{}, len(permissionGroups))
+	for _, group := range permissionGroups {
+		if _, ok := grantedPermissionGroupNamesMap[group.Name]; !ok {
+			continue
+		}
+		for name := group.Name; name != ""; name = parentNamesMap[name] {
+			if _, ok := keptPermissionGroupNamesMap[name]; ok {
+				break
+			}
+			keptPermissionGroupNamesMap[name] = struct{}{}
+		}
+	}
+	keptPermissionGroups := make([]*PermissionGroup, 0, len(keptPermissionGroupNamesMap))
+	for _, group := range permissionGroups {
+		if _, ok := keptPermissionGroupNamesMap[group.Name]; ok {
+			keptPermissionGroups = append(keptPermissionGroups, group)
+		}
+	}
+
+	var permissionNamesMap map[string][]string
+	if param.WithPermissions {
+		var err error
+		if permissionNamesMap, err = getPermissionNamesMap(s.db.WithContext(ctx), grantedPermissionGroupNames); err != nil {
+			return nil, err
+		}
+	}
+
+	tree := buildPermissionGroupTree(keptPermissionGroups, "", permissionNamesMap)
+	walkPermissionGroupTree(tree, func(item *PermissionGroupItem) {
+		_, item.Granted = grantedPermissionGroupNamesMap[item.Name]
+	})
+	if err := s.LocalizePermissionGroupTree(ctx, tree, param.Locale); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// 深度优先遍历权限树
+func walkPermissionGroupTree(tree []*PermissionGroupItem, fn func(item *PermissionGroupItem)) {
+	for _, item := range tree {
+		fn(item)
+		walkPermissionGroupTree(item.PermissionGroups, fn)
+	}
+}
@@ -0,0 +1,323 @@
+package permission
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ChangeUserRolesParam struct {
+	UserID       int64   `json:"user_id" yaml:"user_id"`
+	RoleableType string  `json:"roleable_type" yaml:"roleable_type"`
+	RoleableID   int64   `json:"roleable_id" yaml:"roleable_id"`
+	RoleIDs      []int64 `json:"role_ids" yaml:"role_ids"`
+}
+
+// 为用户添加角色，不影响用户已有的其他角色，已拥有的角色会被忽略
+func (s *PermissionService) AddUserRoles(ctx context.Context, param ChangeUserRolesParam) (err error) {
+	defer s.observe(ctx, "AddUserRoles", time.Now(), &err)
+	return s.addUserRoles(ctx, param, nil)
+}
+
+func (s *PermissionService) addUserRoles(ctx context.Context, param ChangeUserRolesParam, check func(tx *gorm.DB) error) error {
+	return s.changeUserRoles(ctx, param, true, check, func(tx *gorm.DB) error {
+		userRoles := make([]*UserRole, 0, len(param.RoleIDs))
+		for _, roleID := range param.RoleIDs {
+			userRoles = append(userRoles, &UserRole{
+				UserID: param.UserID,
+				RoleID: roleID,
+			})
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "role_id"}},
+			DoNothing: true,
+		}).Create(userRoles).Error
+	})
+}
+
+// 移除用户的指定角色，不影响用户已有的其他角色，未拥有的角色会被忽略
+func (s *PermissionService) RemoveUserRoles(ctx context.Context, param ChangeUserRolesParam) (err error) {
+	defer s.observe(ctx, "RemoveUserRoles", time.Now(), &err)
+	return s.removeUserRoles(ctx, param, nil)
+}
+
+func (s *PermissionService) removeUserRoles(ctx context.Context, param ChangeUserRolesParam, check func(tx *gorm.DB) error) error {
+	return s.changeUserRoles(ctx, param, false, check, func(tx *gorm.DB) error {
+		return tx.Where("user_id = ?", param.UserID).Where("role_id IN ?", param.RoleIDs).Delete(&UserRole{}).Error
+	})
+}
+
+// 以 actorUserID 的身份为用户添加角色，添加的角色的权限组需要都是 actorUserID 在该对象下拥有的权限组
+func (s *PermissionService) AddUserRolesAs(ctx context.Context, actorUserID int64, param ChangeUserRolesParam) (err error) {
+	defer s.observe(ctx, "AddUserRolesAs", time.Now(), &err)
+	return s.addUserRoles(ctx, param, func(tx *gorm.DB) error {
+		return s.checkGrantableRoles(tx, actorUserID, param.RoleableType, param.RoleableID, param.RoleIDs)
+	})
+}
+
+// 以 actorUserID 的身份移除用户的角色，移除的角色的权限组需要都是 actorUserID 在该对象下拥有的权限组，
+// 避免权限较少的管理者移除其他用户更高权限的角色
+func (s *PermissionService) RemoveUserRolesAs(ctx context.Context, actorUserID int64, param ChangeUserRolesParam) (err error) {
+	defer s.observe(ctx, "RemoveUserRolesAs", time.Now(), &err)
+	return s.removeUserRoles(ctx, param, func(tx *gorm.DB) error {
+		return s.checkGrantableRoles(tx, actorUserID, param.RoleableType, param.RoleableID, param.RoleIDs)
+	})
+}
+
+// 在一个事务中校验角色属于对象并修改用户角色，同时检查角色约束和发出事件，只移除角色时不检查职责分离约束
+//
+// check 不为 nil 时在校验角色后、修改用户角色前执行，返回错误时不修改
+func (s *PermissionService) changeUserRoles(ctx context.Context, param ChangeUserRolesParam, add bool, check func(tx *gorm.DB) error, change func(tx *gorm.DB) error) error {
+	if len(param.RoleIDs) == 0 {
+		return nil
+	}
+
+	return s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		var roleIDs []int64
+		if err := tx.Model(&Role{}).
+			Scopes(notDeletedRole).
+			Where("roleable_type = ?", param.RoleableType).
+			Where("roleable_id = ?", param.RoleableID).
+			Where("id IN ?", param.RoleIDs).
+			Pluck("id", &roleIDs).Error; err != nil {
+			return err
+		}
+		roleIDsMap := make(map[int64]struct{}, len(roleIDs))
+		for _, roleID := range roleIDs {
+			roleIDsMap[roleID] = struct{}{}
+		}
+		for _, roleID := range param.RoleIDs {
+			if _, ok := roleIDsMap[roleID]; !ok {
+				return fmt.Errorf("%w: role id %d not found in %s:%d", ErrRoleNotFound, roleID, param.RoleableType, param.RoleableID)
+			}
+		}
+		if check != nil {
+			if err := check(tx); err != nil {
+				return err
+			}
+		}
+
+		var before *UserRolesSnapshot
+		if s.hasHooks() {
+			var err error
+			if before, err = s.userRolesSnapshot(tx, param.UserID, param.RoleableID, param.RoleableType); err != nil {
+				return err
+			}
+		}
+		guard, err := s.newMinHoldersGuard(tx, param.RoleableType, param.RoleableID)
+		if err != nil {
+			return err
+		}
+
+		if err := change(tx); err != nil {
+			return err
+		}
+		if err := s.bumpUserRolesVersions(tx, param.RoleableType, param.RoleableID, param.UserID); err != nil {
+			return err
+		}
+
+		if err := guard.check(tx); err != nil {
+			return err
+		}
+		if add {
+			if err := s.checkRoleConstraints(tx, param.UserID, param.RoleableID, param.RoleableType); err != nil {
+				return err
+			}
+		}
+		if before == nil {
+			return nil
+		}
+		return s.emitUserRolesChanged(tx, emit, before)
+	})
+}
+
+// 移除用户在对象下的全部角色，返回移除前用户在对象下的角色
+//
+// 已软删除角色的用户角色同样被移除，避免恢复角色后重新授予，返回值和事件中的角色包括这些已软删除的角色
+func (s *PermissionService) RemoveUserFromRoleable(ctx context.Context, userID int64, roleableType string, roleableID int64) (_ *UserRolesSnapshot, err error) {
+	defer s.observe(ctx, "RemoveUserFromRoleable", time.Now(), &err)
+	var removed *UserRolesSnapshot
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		roleIDs := tx.Model(&Role{}).Select("id").Where("roleable_type = ?", roleableType).Where("roleable_id = ?", roleableID)
+		removed = &UserRolesSnapshot{
+			UserID:       userID,
+			RoleableType: roleableType,
+			RoleableID:   roleableID,
+		}
+		if err := tx.Model(&UserRole{}).
+			Where("user_id = ?", userID).
+			Where("role_id IN (?)", roleIDs).
+			Order("role_id").Pluck("role_id", &removed.RoleIDs).Error; err != nil {
+			return err
+		}
+		guard, err := s.newMinHoldersGuard(tx, roleableType, roleableID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).
+			Where("role_id IN (?)", roleIDs).
+			Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		if len(removed.RoleIDs) > 0 {
+			if err := s.bumpUserRolesVersions(tx, roleableType, roleableID, userID); err != nil {
+				return err
+			}
+		}
+
+		if err := guard.check(tx); err != nil {
+			return err
+		}
+		return s.emitUserRolesRemoved(emit, removed)
+	}); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// 移除用户在所有对象下的全部角色，比如员工离职，返回移除前用户在各对象下的角色
+//
+// 和 RemoveUserFromRoleable 一样，返回值和事件中的角色包括已软删除的角色
+func (s *PermissionService) RemoveUserEverywhere(ctx context.Context, userID int64) (_ []*UserRolesSnapshot, err error) {
+	defer s.observe(ctx, "RemoveUserEverywhere", time.Now(), &err)
+	var removed []*UserRolesSnapshot
+	if err := s.transaction(ctx, func(tx *gorm.DB, emit emitFunc) error {
+		var roles []*Role
+		if err := tx.Where("id IN (?)", tx.Model(&UserRole{}).Select("role_id").Where("user_id = ?", userID)).
+			Order("roleable_type, roleable_id, id").
+			Find(&roles).Error; err != nil {
+			return err
+		}
+		var guards []*minHoldersGuard
+		for _, role := range roles {
+			if n := len(removed); n > 0 && removed[n-1].RoleableType == role.RoleableType && removed[n-1].RoleableID == role.RoleableID {
+				removed[n-1].RoleIDs = append(removed[n-1].RoleIDs, role.ID)
+				continue
+			}
+			removed = append(removed, &UserRolesSnapshot{
+				UserID:       userID,
+				RoleableType: role.RoleableType,
+				RoleableID:   role.RoleableID,
+				RoleIDs:      []int64{role.ID},
+			})
+			guard, err := s.newMinHoldersGuard(tx, role.RoleableType, role.RoleableID)
+			if err != nil {
+				return err
+			}
+			guards = append(guards, guard)
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		for _, snapshot := range removed {
+			if err := s.bumpUserRolesVersions(tx, snapshot.RoleableType, snapshot.RoleableID, userID); err != nil {
+				return err
+			}
+		}
+
+		for _, guard := range guards {
+			if err := guard.check(tx); err != nil {
+				return err
+			}
+		}
+		for _, snapshot := range removed {
+			if err := s.emitUserRolesRemoved(emit, snapshot); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// 发出用户在对象下的角色被全部移除的事件，用户原本没有角色时不发出事件
+func (s *PermissionService) emitUserRolesRemoved(emit emitFunc, before *UserRolesSnapshot) error {
+	if !s.hasHooks() || len(before.RoleIDs) == 0 {
+		return nil
+	}
+	after := *before
+	after.RoleIDs = []int64{}
+	return emit(&Event{Type: EventUserRolesChanged, Before: before, After: &after})
+}
+
+// 将用户在对象下的角色集合版本加 1，版本行不存在时创建
+func (s *PermissionService) bumpUserRolesVersions(tx *gorm.DB, roleableType string, roleableID int64, userIDs ...int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	versions := make([]*UserRolesVersion, 0, len(userIDs))
+	for _, userID := range userIDs {
+		versions = append(versions, &UserRolesVersion{UserID: userID, RoleableType: roleableType, RoleableID: roleableID, Version: 1})
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "roleable_type"}, {Name: "roleable_id"}},
+		DoUpdates: clause.Set{{
+			Column: clause.Column{Name: "version"},
+			Value:  gorm.Expr("? + 1", clause.Column{Table: s.cachedTableNames.userRolesVersionTableName, Name: "version"}),
+		}},
+	}).CreateInBatches(versions, defaultBulkBatchSize).Error
+}
+
+// 将角色成员在角色所属对象下的角色集合版本加 1，用于删除和恢复角色
+func (s *PermissionService) bumpRoleUserRolesVersions(tx *gorm.DB, role *Role) error {
+	var userIDs []int64
+	if err := tx.Model(&UserRole{}).Where("role_id = ?", role.ID).Order("user_id").Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+	return s.bumpUserRolesVersions(tx, role.RoleableType, role.RoleableID, userIDs...)
+}
+
+// 版本和 expectedVersion 一致时加 1，否则返回 VersionConflictError
+//
+// 带版本条件的更新会锁住版本行，并发的调用在提交前排队，先提交的一方使其他调用的版本失效
+func (s *PermissionService) compareAndBumpUserRolesVersion(tx *gorm.DB, userID int64, roleableType string, roleableID int64, expectedVersion string) error {
+	conflict := func(actualVersion string) error {
+		return &VersionConflictError{
+			Resource:        fmt.Sprintf("user_roles:%d@%s:%d", userID, roleableType, roleableID),
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   actualVersion,
+		}
+	}
+	expected, err := strconv.ParseInt(expectedVersion, 10, 64)
+	if err != nil {
+		actualVersion, err := s.getUserRolesVersion(tx, userID, roleableID, roleableType)
+		if err != nil {
+			return err
+		}
+		return conflict(actualVersion)
+	}
+
+	// 版本行不存在时按版本 0 创建，保证后续更新有行可锁
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&UserRolesVersion{
+		UserID:       userID,
+		RoleableType: roleableType,
+		RoleableID:   roleableID,
+	}).Error; err != nil {
+		return err
+	}
+	result := tx.Model(&UserRolesVersion{}).
+		Where("user_id = ?", userID).
+		Where("roleable_type = ?", roleableType).
+		Where("roleable_id = ?", roleableID).
+		Where("version = ?", expected).
+		Update("version", gorm.Expr("version + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	actualVersion, err := s.getUserRolesVersion(tx, userID, roleableID, roleableType)
+	if err != nil {
+		return err
+	}
+	return conflict(actualVersion)
+}